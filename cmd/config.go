@@ -0,0 +1,246 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// Config file shared by all commands. A profile maps flag names to values. Values can be
+// strings, booleans, numbers or lists for flags that can be repeated. A profile may also
+// contain sections named after commands, whose flags only apply to that command and take
+// precedence over flags at the top level of the profile.
+type Config struct {
+	// Profile used when -profile is not given
+	DefaultProfile string                    `json:"default_profile"`
+	Profiles       map[string]map[string]any `json:"profiles"`
+}
+
+const (
+	appName         = "soundtrack-downloader"
+	defaultProfile  = "default"
+	configFlag      = "config"
+	profileFlag     = "profile"
+	printConfigFlag = "print-config"
+)
+
+// $XDG_CONFIG_HOME/soundtrack-downloader/config.json or equivalent on other platforms
+func DefaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, appName, "config.json")
+}
+
+func ReadConfig(r io.Reader) (*Config, error) {
+	var config Config
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return &config, nil
+}
+
+// Records raw values so that the effective settings can be printed back in the config format
+type rawRecorder struct {
+	name   string
+	raws   map[string][]string
+	isBool bool
+}
+
+func (r *rawRecorder) String() string { return "" }
+
+func (r *rawRecorder) Set(value string) error {
+	r.raws[r.name] = append(r.raws[r.name], value)
+	return nil
+}
+
+func (r *rawRecorder) IsBoolFlag() bool { return r.isBool }
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// Raw values of flags given on the command line. Errors are left to the actual parsing
+func recordArgs(fs *flag.FlagSet, args []string) map[string][]string {
+	raws := map[string][]string{}
+	shadow := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	shadow.SetOutput(io.Discard)
+	shadow.Usage = func() {}
+	fs.VisitAll(func(f *flag.Flag) {
+		shadow.Var(&rawRecorder{f.Name, raws, isBoolFlag(f)}, f.Name, "")
+	})
+	_ = shadow.Parse(args)
+	return raws
+}
+
+// Flat list of values of a profile for a command, and the keys from the command section
+func (c *Config) profileValues(profile, command string) (map[string]any, []string, error) {
+	if profile == "" {
+		profile = c.DefaultProfile
+	}
+	if profile == "" {
+		profile = defaultProfile
+	}
+	values, ok := c.Profiles[profile]
+	if !ok {
+		if profile == defaultProfile {
+			return map[string]any{}, nil, nil
+		}
+		return nil, nil, fmt.Errorf("profile not found: %s", profile)
+	}
+	res := map[string]any{}
+	for k, v := range values {
+		if _, ok := v.(map[string]any); !ok {
+			res[k] = v
+		}
+	}
+	section, _ := values[command].(map[string]any)
+	maps.Copy(res, section)
+	return res, slices.Collect(maps.Keys(section)), nil
+}
+
+func setFlagValue(fs *flag.FlagSet, raws map[string][]string, name string, value any) error {
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+	case bool:
+		raw = strconv.FormatBool(v)
+	case float64:
+		raw = strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		for _, item := range v {
+			if err := setFlagValue(fs, raws, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported value: %v", value)
+	}
+	if err := fs.Set(name, raw); err != nil {
+		return err
+	}
+	raws[name] = append(raws[name], raw)
+	return nil
+}
+
+// Sets flags not given on the command line from the profile. Unknown flags at the top level
+// of a profile are ignored because they may belong to other commands
+func (c *Config) apply(fs *flag.FlagSet, raws map[string][]string, profile, command string) error {
+	values, sectionKeys, err := c.profileValues(profile, command)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if name == configFlag || name == profileFlag || name == printConfigFlag {
+			return fmt.Errorf("%s cannot be set in a profile", name)
+		}
+		if fs.Lookup(name) == nil {
+			if slices.Contains(sectionKeys, name) {
+				return fmt.Errorf("unknown flag for %s in profile: %s", command, name)
+			}
+			continue
+		}
+		if _, ok := raws[name]; ok {
+			continue
+		}
+		if err := setFlagValue(fs, raws, name, values[name]); err != nil {
+			return fmt.Errorf("invalid value in profile for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Effective values of all flags, in the format of a profile. Unset flags without a
+// meaningful default are left out
+func effectiveConfig(fs *flag.FlagSet, raws map[string][]string) map[string]any {
+	res := map[string]any{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == configFlag || f.Name == profileFlag || f.Name == printConfigFlag {
+			return
+		}
+		raw := raws[f.Name]
+		if len(raw) == 0 {
+			// Only built-in flag types have meaningful defaults
+			if _, ok := f.Value.(flag.Getter); !ok || (f.DefValue == "" && !isBoolFlag(f)) {
+				return
+			}
+			raw = []string{f.DefValue}
+		}
+		if isBoolFlag(f) {
+			b, _ := strconv.ParseBool(raw[len(raw)-1])
+			res[f.Name] = b
+		} else if len(raw) == 1 {
+			res[f.Name] = raw[0]
+		} else {
+			res[f.Name] = raw
+		}
+	})
+	return res
+}
+
+// Replaces flag.Parse for commands supporting config files. It defines -config, -profile and
+// -print-config, parses the command line, then fills the flags not given from the selected
+// profile. With -print-config the effective settings are printed and the program exits.
+func ParseFlags(command string) error {
+	osOpen := func(name string) (io.ReadCloser, error) {
+		return os.Open(name) // covariance
+	}
+	printed, err := parseFlags(flag.CommandLine, os.Args[1:], osOpen, os.Stdout, command)
+	if printed {
+		os.Exit(0)
+	}
+	return err
+}
+
+func parseFlags(
+	fs *flag.FlagSet,
+	args []string,
+	osOpen func(name string) (io.ReadCloser, error),
+	stdout io.Writer,
+	command string,
+) (bool, error) {
+	configPath := fs.String(configFlag, "", "Path to the config file. Default to soundtrack-downloader/config.json in the user config directory (e.g. $XDG_CONFIG_HOME) if it exists")
+	profile := fs.String(profileFlag, "", "Profile in the config file to use. Flags given on the command line take precedence. Default to default_profile in the config file, or 'default'")
+	printConfig := fs.Bool(printConfigFlag, false, "Print the effective settings in the config file format and exit. Default: false")
+	raws := recordArgs(fs, args)
+	if err := fs.Parse(args); err != nil {
+		return false, err
+	}
+
+	path := *configPath
+	if path == "" {
+		path = DefaultConfigPath()
+	}
+	if f, err := osOpen(path); err == nil {
+		defer f.Close()
+		config, err := ReadConfig(f)
+		if err != nil {
+			return false, err
+		}
+		if err := config.apply(fs, raws, *profile, command); err != nil {
+			return false, err
+		}
+	} else if *configPath != "" || !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("failed to open config: %w", err)
+	} else if *profile != "" {
+		return false, fmt.Errorf("profile %s is given but no config file is found", *profile)
+	}
+
+	if !*printConfig {
+		return false, nil
+	}
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return true, encoder.Encode(effectiveConfig(fs, raws))
+}
@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type listFlags []string
+
+func (l *listFlags) String() string { return fmt.Sprintf("%v", *l) }
+
+func (l *listFlags) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+const testConfig = `{
+  "default_profile": "archive",
+  "profiles": {
+    "archive": {
+      "format": "FLAC",
+      "fix-tags": true,
+      "tag": ["ARTIST=a", "ARTIST=b"],
+      "unrelated": 1,
+      "downloader": {"format": "MP3", "retries": 3},
+      "meta": {"retries": 5}
+    },
+    "broken": {
+      "downloader": {"typo": true}
+    }
+  }
+}`
+
+type testFlags struct {
+	fs      *flag.FlagSet
+	format  *string
+	fixTags *bool
+	retries *int
+	tags    *listFlags
+}
+
+func newTestFlags() *testFlags {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	t := &testFlags{
+		fs:      fs,
+		format:  fs.String("format", "OGG", ""),
+		fixTags: fs.Bool("fix-tags", false, ""),
+		retries: fs.Int("retries", 0, ""),
+		tags:    &listFlags{},
+	}
+	fs.Var(t.tags, "tag", "")
+	return t
+}
+
+func TestParseFlags(t *testing.T) {
+	mkOpen := func(name string) (io.ReadCloser, error) {
+		if name != "config.json" {
+			return nil, os.ErrNotExist
+		}
+		return io.NopCloser(strings.NewReader(testConfig)), nil
+	}
+
+	t.Run("happy path applies the default profile with command section", func(t *testing.T) {
+		f := newTestFlags()
+		printed, err := parseFlags(f.fs, []string{"-config", "config.json"}, mkOpen, io.Discard, "downloader")
+		if err != nil || printed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *f.format != "MP3" || !*f.fixTags || *f.retries != 3 || !reflect.DeepEqual(*f.tags, listFlags{"ARTIST=a", "ARTIST=b"}) {
+			t.Fatalf("unexpected values: %s %v %d %v", *f.format, *f.fixTags, *f.retries, *f.tags)
+		}
+	})
+
+	t.Run("happy path command line takes precedence", func(t *testing.T) {
+		f := newTestFlags()
+		_, err := parseFlags(f.fs, []string{"-config", "config.json", "-format", "WAV", "-fix-tags=false", "-tag", "ARTIST=c"}, mkOpen, io.Discard, "meta")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *f.format != "WAV" || *f.fixTags || *f.retries != 5 || !reflect.DeepEqual(*f.tags, listFlags{"ARTIST=c"}) {
+			t.Fatalf("unexpected values: %s %v %d %v", *f.format, *f.fixTags, *f.retries, *f.tags)
+		}
+	})
+
+	t.Run("happy path without config file", func(t *testing.T) {
+		f := newTestFlags()
+		if _, err := parseFlags(f.fs, []string{"-format", "WAV"}, mkOpen, io.Discard, "downloader"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *f.format != "WAV" {
+			t.Fatalf("unexpected value: %s", *f.format)
+		}
+	})
+
+	t.Run("happy path prints effective config", func(t *testing.T) {
+		f := newTestFlags()
+		buffer := new(bytes.Buffer)
+		printed, err := parseFlags(f.fs, []string{"-config", "config.json", "-print-config", "-tag", "X=1", "-tag", "Y=2"}, mkOpen, buffer, "downloader")
+		if err != nil || !printed {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var res map[string]any
+		if err := json.Unmarshal(buffer.Bytes(), &res); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := map[string]any{
+			"format":   "MP3",
+			"fix-tags": true,
+			"retries":  "3",
+			"tag":      []any{"X=1", "Y=2"},
+		}
+		if !reflect.DeepEqual(res, expected) {
+			t.Fatalf("expected %v, got %v", expected, res)
+		}
+	})
+
+	t.Run("explicit config file not found", func(t *testing.T) {
+		f := newTestFlags()
+		if _, err := parseFlags(f.fs, []string{"-config", "other.json"}, mkOpen, io.Discard, "downloader"); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+
+	t.Run("profile not found", func(t *testing.T) {
+		f := newTestFlags()
+		if _, err := parseFlags(f.fs, []string{"-config", "config.json", "-profile", "none"}, mkOpen, io.Discard, "downloader"); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+
+	t.Run("unknown flag in command section", func(t *testing.T) {
+		f := newTestFlags()
+		if _, err := parseFlags(f.fs, []string{"-config", "config.json", "-profile", "broken"}, mkOpen, io.Discard, "downloader"); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/cleoold/soundtrack-downloader/cmd"
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+type listFlags []string
+
+func (l *listFlags) String() string {
+	return fmt.Sprintf("%v", []string(*l))
+}
+
+func (l *listFlags) Set(value string) error {
+	for part := range strings.SplitSeq(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*l = append(*l, part)
+		}
+	}
+	return nil
+}
+
+type feedOptions struct {
+	filter             pkg.FeedFilter
+	dir                string
+	download           bool
+	fixTags            bool
+	trackFormatRanking pkg.TrackFormatRanking
+	historyFile        string
+	seenFile           string
+}
+
+// Checks the album list once. Albums are remembered as seen once they are skipped or downloaded,
+// so failed downloads are retried on the next poll
+func pollFeed(ctx context.Context, client pkg.HttpDoClient, logger *slog.Logger, opts feedOptions, listUrl string) error {
+	albums, err := pkg.FetchAlbumList(ctx, client, listUrl)
+	if err != nil {
+		return err
+	}
+	seen, err := pkg.LoadSeenUrls(opts.seenFile)
+	if err != nil {
+		return err
+	}
+	var history []pkg.HistoryEntry
+	if opts.historyFile != "" {
+		if history, err = pkg.LoadHistory(opts.historyFile); err != nil {
+			logger.Warn("failed to read history: " + err.Error())
+		}
+	}
+
+	var newlySeen []string
+	var errs []error
+	for _, albumInfo := range albums {
+		if seen[albumInfo.Url] || ctx.Err() != nil {
+			continue
+		}
+		if !opts.filter.Matches(&albumInfo) {
+			logger.Debug("skipping album not matching filters", "name", albumInfo.Name)
+			newlySeen = append(newlySeen, albumInfo.Url)
+			continue
+		}
+		logger.Info("new album", "name", albumInfo.Name, "url", albumInfo.Url, "platforms", albumInfo.Platforms, "albumType", albumInfo.AlbumType, "year", albumInfo.Year)
+		if !opts.download {
+			newlySeen = append(newlySeen, albumInfo.Url)
+			continue
+		}
+		if last := pkg.LastDownload(history, albumInfo.Url); last != nil && last.Complete {
+			logger.Info("skipped album as it was already downloaded", "folder", last.Folder)
+			newlySeen = append(newlySeen, albumInfo.Url)
+			continue
+		}
+		plan, err := pkg.FetchAlbum(ctx, client, logger, opts.dir, albumInfo.Url, false, false, false, false, false, pkg.DownloadAllTracks, opts.trackFormatRanking, nil, 0, false)
+		if plan != nil && opts.historyFile != "" {
+			recordHistory(logger, opts.historyFile, plan)
+		}
+		if err == nil && opts.fixTags {
+			err = fixAlbumTags(logger, plan, nil)
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error(err.Error(), "url", albumInfo.Url)
+				errs = append(errs, err)
+			}
+			continue
+		}
+		newlySeen = append(newlySeen, albumInfo.Url)
+	}
+	if err := pkg.AppendSeenUrls(opts.seenFile, newlySeen); err != nil {
+		return fmt.Errorf("failed to record seen albums: %w", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d albums failed to download", len(errs))
+	}
+	return nil
+}
+
+// Polls a list of recently added albums and downloads the ones matching filters: downloader feed [flags]
+func feedMain() {
+	logger := cmd.DefaultLogger()
+	flag.Usage = cmd.PrintUsage
+	logFlags := cmd.DefineLogFlags()
+	urlFlag := flag.String("url", "https://downloads.khinsider.com/latest", "Page listing albums to poll")
+	platformFlag := listFlags{}
+	flag.Var(&platformFlag, "platform", "Only albums for one of these platforms (example: -platform Windows,Switch). Default: any")
+	albumTypeFlag := listFlags{}
+	flag.Var(&albumTypeFlag, "album-type", "Only albums of one of these types (example: -album-type Soundtrack). Default: any")
+	yearFlag := listFlags{}
+	flag.Var(&yearFlag, "year", "Only albums of one of these years (example: -year 2024,2025). Default: any")
+	keywordFlag := listFlags{}
+	flag.Var(&keywordFlag, "keyword", "Only albums whose name contains one of these words, ignoring case. Default: any")
+	downloadFlag := flag.Bool("download", false, "Download matching albums instead of only reporting them. Default: false")
+	dirFlag := flag.String("dir", ".", "Folder to download albums into")
+	fixTags := flag.Bool("fix-tags", false, "Fix tags of downloaded albums. Default: false")
+	trackFormatPreferenceFlag := formatPreferenceFlags{}
+	flag.Var(&trackFormatPreferenceFlag, "track-format-preference", "File format preference, as for downloading. Default to 'FLAC,MP3,OGG,*'")
+	seenFileFlag := flag.String("seen-file", "", "File recording the albums already seen in the list. Default: soundtrack-downloader/feed-seen.txt in the user config directory")
+	intervalFlag := flag.Duration("interval", 0, "Keep running and poll again after this duration (example: -interval 1h). Default: 0, poll once, e.g. from cron")
+	historyFileFlag := flag.String("history-file", "", "File recording the albums downloaded. Default: soundtrack-downloader/history.jsonl in the user config directory")
+	noHistoryFlag := flag.Bool("no-history", false, "Don't read or record the download history. Default: false")
+	clientFlags := defineClientFlags()
+	if err := cmd.ParseFlags("feed"); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger, logFile, err := logFlags.NewLogger()
+	if err != nil {
+		cmd.DefaultLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	if len(trackFormatPreferenceFlag) == 0 {
+		trackFormatPreferenceFlag = formatPreferenceFlags{"FLAC", "MP3", "OGG", "*"}
+	}
+	opts := feedOptions{
+		filter: pkg.FeedFilter{
+			Platforms:  platformFlag,
+			AlbumTypes: albumTypeFlag,
+			Years:      yearFlag,
+			Keywords:   keywordFlag,
+		},
+		dir:                *dirFlag,
+		download:           *downloadFlag,
+		fixTags:            *fixTags,
+		trackFormatRanking: pkg.TrackFormatRanking(trackFormatPreferenceFlag),
+		historyFile:        *historyFileFlag,
+		seenFile:           *seenFileFlag,
+	}
+	if *noHistoryFlag {
+		opts.historyFile = ""
+	} else if opts.historyFile == "" {
+		opts.historyFile = pkg.DefaultHistoryPath()
+	}
+	if opts.seenFile == "" {
+		opts.seenFile = pkg.DefaultFeedSeenPath()
+	}
+	client, err := clientFlags.newClient(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	failed := false
+	runEvery(ctx, *intervalFlag, func() {
+		logger.Info("polling " + *urlFlag)
+		if err := pollFeed(ctx, client, logger, opts, *urlFlag); err != nil && ctx.Err() == nil {
+			logger.Error(err.Error())
+			failed = true
+		}
+	})
+	if ctx.Err() != nil {
+		logger.Error("interrupted")
+		os.Exit(exitInterrupted)
+	}
+	if failed {
+		os.Exit(exitFailure)
+	}
+}
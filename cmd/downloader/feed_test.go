@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+type stubClient map[string]map[string]struct {
+	code    int
+	content string
+}
+
+func (m stubClient) Do(req *http.Request) (*http.Response, error) {
+	pair := m[req.URL.String()][req.Method]
+	return &http.Response{
+		StatusCode: pair.code,
+		Body:       io.NopCloser(strings.NewReader(pair.content)),
+	}, nil
+}
+
+func readTestdata(t *testing.T, name string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("../../pkg/testdata", name))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(content)
+}
+
+func TestListFlags(t *testing.T) {
+	l := listFlags{}
+	l.Set("Windows, Switch")
+	l.Set("PS5,")
+	expected := listFlags{"Windows", "Switch", "PS5"}
+	if !reflect.DeepEqual(l, expected) {
+		t.Fatalf("expected %v, got %v", expected, l)
+	}
+}
+
+func TestPollFeed(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	song1 := readTestdata(t, "song1.html")
+	// My Album 1 downloads, Other Game fails, and the others don't match the filter
+	client := stubClient{
+		"https://example.com/latest":            {"GET": {http.StatusOK, readTestdata(t, "latest.html")}},
+		"https://example.com/my-album-1":        {"GET": {http.StatusOK, readTestdata(t, "album1_home.html")}},
+		"https://example.com/01.%2520song1.mp3": {"GET": {http.StatusOK, song1}},
+		"https://example.com/01.%2520song2.mp3": {"GET": {http.StatusOK, strings.ReplaceAll(strings.ReplaceAll(song1, "song1", "song2"), "01", "02")}},
+		"https://download.com/Cover.jpg":        {"GET": {http.StatusOK, "content of cover"}},
+		"https://download.com/01.%20song1.flac": {"GET": {http.StatusOK, "content of song1"}},
+		"https://download.com/02.%20song2.flac": {"GET": {http.StatusOK, "content of song2"}},
+		"https://example.com/other-game":        {"GET": {http.StatusInternalServerError, ""}},
+	}
+	notMatching := []string{"https://example.com/my-album-2", "https://example.com/unknown"}
+	tests := []struct {
+		name     string
+		download bool
+		seen     []string
+		history  []pkg.HistoryEntry
+		// Seen after the poll, and whether My Album 1 is downloaded
+		expectedSeen       []string
+		expectedDownloaded bool
+		expectedErr        bool
+	}{
+		{
+			name:         "happy path reports new albums",
+			expectedSeen: append([]string{"https://example.com/my-album-1", "https://example.com/other-game"}, notMatching...),
+		},
+		{
+			name:               "happy path downloads matching albums and retries failures",
+			download:           true,
+			expectedSeen:       append([]string{"https://example.com/my-album-1"}, notMatching...),
+			expectedDownloaded: true,
+			expectedErr:        true,
+		},
+		{
+			name:         "happy path skips seen albums",
+			download:     true,
+			seen:         []string{"https://example.com/my-album-1", "https://example.com/other-game"},
+			expectedSeen: append([]string{"https://example.com/my-album-1", "https://example.com/other-game"}, notMatching...),
+		},
+		{
+			name:     "happy path skips albums completely downloaded before",
+			download: true,
+			seen:     []string{"https://example.com/other-game"},
+			history: []pkg.HistoryEntry{
+				{Url: "https://example.com/my-album-1", Folder: "elsewhere/My Album 1", Status: pkg.StatusComplete, Complete: true},
+			},
+			expectedSeen: append([]string{"https://example.com/my-album-1", "https://example.com/other-game"}, notMatching...),
+		},
+		{
+			name:     "happy path downloads albums only partly downloaded before",
+			download: true,
+			seen:     []string{"https://example.com/other-game"},
+			history: []pkg.HistoryEntry{
+				{Url: "https://example.com/my-album-1", Folder: "elsewhere/My Album 1", Status: pkg.StatusComplete},
+			},
+			expectedSeen:       append([]string{"https://example.com/my-album-1", "https://example.com/other-game"}, notMatching...),
+			expectedDownloaded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := feedOptions{
+				filter:             pkg.FeedFilter{AlbumTypes: []string{"Soundtrack"}},
+				dir:                dir,
+				download:           tt.download,
+				trackFormatRanking: pkg.TrackFormatRanking{"FLAC"},
+				seenFile:           filepath.Join(dir, "seen.txt"),
+			}
+			if err := pkg.AppendSeenUrls(opts.seenFile, tt.seen); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.history != nil {
+				opts.historyFile = filepath.Join(dir, "history.jsonl")
+				for _, entry := range tt.history {
+					entry.Time = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+					if err := pkg.AppendHistory(opts.historyFile, entry); err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+				}
+			}
+
+			err := pollFeed(context.Background(), client, logger, opts, "https://example.com/latest")
+			if (err != nil) != tt.expectedErr {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			seen, err := pkg.LoadSeenUrls(opts.seenFile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var seenUrls []string
+			for url := range seen {
+				seenUrls = append(seenUrls, url)
+			}
+			slices.Sort(seenUrls)
+			slices.Sort(tt.expectedSeen)
+			if !reflect.DeepEqual(seenUrls, tt.expectedSeen) {
+				t.Fatalf("expected %v, got %v", tt.expectedSeen, seenUrls)
+			}
+			content, err := os.ReadFile(filepath.Join(dir, "My Album 1", "01. song1.flac"))
+			if downloaded := err == nil && string(content) == "content of song1"; downloaded != tt.expectedDownloaded {
+				t.Fatalf("expected downloaded %v, got %v", tt.expectedDownloaded, downloaded)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cleoold/soundtrack-downloader/cmd"
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+func printHistory(w io.Writer, entries []pkg.HistoryEntry, format string, files bool) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []pkg.HistoryEntry{}
+		}
+		return encoder.Encode(entries)
+	case "text":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, entry := range entries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Time.Local().Format(time.DateTime), entry.Status, entry.Name, entry.Folder, entry.Url)
+			if !files {
+				continue
+			}
+			for _, file := range entry.Files {
+				fmt.Fprintf(tw, "\t%s\t%s\t%s\t\n", file.Outcome, file.Path, file.Reason)
+			}
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("invalid format: %s", format)
+}
+
+// Lists the download history: downloader history [flags]
+func historyMain() {
+	logger := cmd.DefaultLogger()
+	flag.Usage = cmd.PrintUsage
+	logFlags := cmd.DefineLogFlags()
+	historyFileFlag := flag.String("history-file", "", "File recording the albums downloaded. Default: soundtrack-downloader/history.jsonl in the user config directory")
+	searchFlag := flag.String("search", "", "Only list albums whose URL, name or folder contains this text, ignoring case")
+	urlFlag := flag.String("url", "", "Only list downloads of this album URL")
+	filesFlag := flag.Bool("files", false, "Also list the outcome of each file. Default: false")
+	formatFlag := flag.String("format", "text", "Output format: text or json")
+	if err := cmd.ParseFlags("history"); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger, logFile, err := logFlags.NewLogger()
+	if err != nil {
+		cmd.DefaultLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	if *historyFileFlag == "" {
+		*historyFileFlag = pkg.DefaultHistoryPath()
+	}
+
+	entries, err := pkg.LoadHistory(*historyFileFlag)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if *urlFlag != "" {
+		entries = pkg.AlbumHistory(entries, *urlFlag)
+	}
+	if *searchFlag != "" {
+		entries = pkg.SearchHistory(entries, *searchFlag)
+	}
+	if err := printHistory(os.Stdout, entries, *formatFlag, *filesFlag); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+}
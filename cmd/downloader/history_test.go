@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+func TestPrintHistory(t *testing.T) {
+	entries := []pkg.HistoryEntry{{
+		Url:    "https://example.com/album1",
+		Name:   "My Album 1",
+		Folder: "My Album 1",
+		Time:   time.Date(2024, 1, 18, 12, 0, 0, 0, time.Local),
+		Status: pkg.StatusIncomplete,
+		Files: []pkg.HistoryFile{
+			{Path: "My Album 1/01.flac", Outcome: pkg.FileDownloaded},
+			{Path: "My Album 1/02.flac", Outcome: pkg.FileFailed, Reason: "unexpected response: 500"},
+		},
+	}}
+
+	t.Run("happy path text with files", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printHistory(&buf, entries, "text", true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 3 || !strings.HasPrefix(lines[0], "2024-01-18 12:00:00  incomplete  My Album 1") || !strings.Contains(lines[2], "failed") {
+			t.Fatalf("unexpected output: %s", buf.String())
+		}
+	})
+
+	t.Run("happy path empty json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printHistory(&buf, nil, "json", false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if buf.String() != "[]\n" {
+			t.Fatalf("expected [], got %s", buf.String())
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		if err := printHistory(&bytes.Buffer{}, entries, "csv", false); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
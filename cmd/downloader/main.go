@@ -2,12 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"os"
+	"os/signal"
+	"path"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/cleoold/soundtrack-downloader/cmd"
 	"github.com/cleoold/soundtrack-downloader/pkg"
@@ -15,24 +23,16 @@ import (
 
 type trackFlags pkg.TrackNumberSet
 
-func (t trackFlags) String() string {
-	return fmt.Sprintf("%v", pkg.TrackNumberSet(t))
+func (t *trackFlags) String() string {
+	return pkg.TrackNumberSet(*t).String()
 }
 
-func (t trackFlags) Set(value string) error {
-	tt := pkg.TrackNumberSet(t)
-	for part := range strings.SplitSeq(value, ",") {
-		part := strings.TrimSpace(part)
-		// Note: no validation like convertibility to int is done here
-		sp := strings.Split(part, "-")
-		if len(sp) == 1 {
-			tt.Add(pkg.TrackNumberKey{TrackNumber: sp[0]})
-		} else if len(sp) == 2 {
-			tt.Add(pkg.TrackNumberKey{DiscNumber: sp[0], TrackNumber: sp[1]})
-		} else {
-			return fmt.Errorf("invalid track number format: %s", value)
-		}
+func (t *trackFlags) Set(value string) error {
+	set, err := pkg.ParseTrackSelectors(value)
+	if err != nil {
+		return err
 	}
+	*t = append(*t, set...)
 	return nil
 }
 
@@ -49,9 +49,243 @@ func (f *formatPreferenceFlags) Set(value string) error {
 	return nil
 }
 
+type byteSizeFlag int64
+
+func (b *byteSizeFlag) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return pkg.FormatByteSize(int64(*b))
+}
+
+func (b *byteSizeFlag) Set(value string) error {
+	size, err := pkg.ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = byteSizeFlag(size)
+	return nil
+}
+
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	return fmt.Sprintf("%v", http.Header(h))
+}
+
+func (h headerFlags) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header format: %s", value)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(v))
+	return nil
+}
+
+func newHttpClient(userAgent, proxy string, connectTimeout, readTimeout time.Duration, headers http.Header, cookiesFile string) (pkg.HttpDoClient, error) {
+	opts := pkg.HttpClientOptions{
+		UserAgent:      userAgent,
+		Proxy:          proxy,
+		ConnectTimeout: connectTimeout,
+		ReadTimeout:    readTimeout,
+		Headers:        headers,
+	}
+	if cookiesFile != "" {
+		f, err := os.Open(cookiesFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if opts.Cookies, err = pkg.ReadNetscapeCookies(f); err != nil {
+			return nil, fmt.Errorf("failed to read cookies: %w", err)
+		}
+	}
+	return pkg.NewHttpClient(opts)
+}
+
+// Flags of the HTTP client shared by the download commands
+type clientFlags struct {
+	rateLimit      *float64
+	rateBurst      *int
+	minDelay       *time.Duration
+	bandwidthLimit byteSizeFlag
+	userAgent      *string
+	proxy          *string
+	connectTimeout *time.Duration
+	readTimeout    *time.Duration
+	header         headerFlags
+	cookies        *string
+	cache          *bool
+	cacheDirectory *string
+	cacheTtl       *time.Duration
+	offline        *bool
+}
+
+func defineClientFlags() *clientFlags {
+	c := &clientFlags{header: headerFlags{}}
+	c.rateLimit = flag.Float64("rate-limit", 0, "Maximum number of requests per second to each host. Default: 0, unlimited")
+	c.rateBurst = flag.Int("rate-burst", 1, "Number of requests to each host that can be made at once before -rate-limit applies")
+	c.minDelay = flag.Duration("min-delay", 0, "Minimum delay between requests to each host (example: -min-delay 500ms). Default: 0")
+	flag.Var(&c.bandwidthLimit, "bandwidth-limit", "Maximum download speed per second of all files together (example: -bandwidth-limit 2MB). Default: unlimited")
+	c.userAgent = flag.String("user-agent", "", "User-Agent header of requests. Default to Go's")
+	c.proxy = flag.String("proxy", "", "Proxy URL, e.g. http://host:port or socks5://host:port. Default to the HTTP_PROXY and HTTPS_PROXY environment variables")
+	c.connectTimeout = flag.Duration("connect-timeout", 0, "Timeout for connecting to a host (example: -connect-timeout 10s). Default: 0, no timeout")
+	c.readTimeout = flag.Duration("read-timeout", 0, "Timeout for waiting for a response, and between reads of a file being downloaded (example: -read-timeout 30s). Default: 0, no timeout")
+	flag.Var(&c.header, "header", "Extra header to send with requests. Format: -header 'Name: value'. Multiple are supported")
+	c.cookies = flag.String("cookies", "", "Cookies file in the Netscape cookies.txt format, as exported by browsers")
+	c.cache = flag.Bool("cache", false, "Cache album and track pages on disk. Default: false")
+	c.cacheDirectory = flag.String("cache-dir", "", "Directory of the page cache. Default: soundtrack-downloader in the user cache directory, e.g. ~/.cache/soundtrack-downloader")
+	c.cacheTtl = flag.Duration("cache-ttl", 24*time.Hour, "Time after which cached pages are revalidated with the server, and removed by -prune-cache")
+	c.offline = flag.Bool("offline", false, "Only read pages from the cache and never access the network. Implies -cache. Default: false")
+	return c
+}
+
+func (c *clientFlags) cacheDir() (string, error) {
+	if *c.cacheDirectory == "" {
+		return pkg.DefaultHttpCacheDir()
+	}
+	return *c.cacheDirectory, nil
+}
+
+func (c *clientFlags) newClient(logger *slog.Logger) (pkg.HttpDoClient, error) {
+	client, err := newHttpClient(*c.userAgent, *c.proxy, *c.connectTimeout, *c.readTimeout, http.Header(c.header), *c.cookies)
+	if err != nil {
+		return nil, err
+	}
+	if *c.rateLimit > 0 || *c.minDelay > 0 || c.bandwidthLimit > 0 {
+		client = pkg.NewRateLimitedClient(client, *c.rateLimit, *c.rateBurst, *c.minDelay, int64(c.bandwidthLimit))
+	}
+	if *c.cache || *c.offline {
+		dir, err := c.cacheDir()
+		if err != nil && *c.offline {
+			return nil, fmt.Errorf("no cache directory for offline mode: %w", err)
+		} else if err != nil {
+			logger.Warn("cache disabled, as there is no cache directory: " + err.Error())
+			return client, nil
+		}
+		// Outermost so that cache hits are not rate limited
+		client = pkg.NewCachingClient(client, dir, *c.cacheTtl, *c.offline)
+	}
+	return client, nil
+}
+
+// Fixes tags of a downloaded album, in each format subfolder if several formats were downloaded
+func fixAlbumTags(logger *slog.Logger, plan *pkg.DownloadPlan, joinFormats pkg.InsStringKeySet) error {
+	info := plan.Album
+	folders := []string{plan.Folder}
+	if len(info.FormatFolders) > 0 {
+		folders = folders[:0]
+		for _, sub := range slices.Sorted(maps.Values(info.FormatFolders)) {
+			folders = append(folders, path.Join(plan.Folder, sub))
+		}
+	}
+	for _, folder := range folders {
+		logger.Info("fixing tags", "folder", folder)
+		if err := pkg.FixTags(logger, pkg.AlbumInfoToTags(info), pkg.AlbumInfoToFileTags(info), nil, pkg.TagCleanup{}, joinFormats, folder, false, false, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flags recorded in the history. Others such as -header, -cookies and -proxy may hold credentials
+var historyFlags = []string{
+	"url", "from-info", "from-plan", "track", "track-format-preference", "formats", "interactive",
+	"no-download-image", "no-download-track", "no-download", "overwrite", "max-size",
+	"downgrade-over-budget", "output-archive", "fix-tags", "join-multi-values",
+}
+
+// Set flags of historyFlags, by name
+func historyOptions(flags *flag.FlagSet) map[string]string {
+	options := map[string]string{}
+	flags.Visit(func(f *flag.Flag) {
+		if slices.Contains(historyFlags, f.Name) {
+			options[f.Name] = f.Value.String()
+		}
+	})
+	return options
+}
+
+// Appends the outcome of a download to the history, with the download flags that were set
+func recordHistory(logger *slog.Logger, historyFile string, plan *pkg.DownloadPlan) {
+	options := historyOptions(flag.CommandLine)
+	if err := pkg.AppendHistory(historyFile, pkg.NewHistoryEntry(plan, options, time.Now())); err != nil {
+		logger.Warn("failed to write history: " + err.Error())
+	}
+}
+
+// Runs fn, then again after each interval until ctx is done. With no interval, fn runs once
+func runEvery(ctx context.Context, interval time.Duration, fn func()) {
+	for {
+		fn()
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func readJSONFile(name string, v any) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+// Exit codes besides 2 for invalid flags
+const (
+	exitFailure = 1
+	// Some files of the album failed to download
+	exitIncomplete = 3
+	// The album page does not exist
+	exitNotFound   = 4
+	exitOverBudget = 5
+	// Stopped by SIGINT or SIGTERM, as shells report for SIGINT
+	exitInterrupted = 130
+)
+
+func exitCode(err error) int {
+	var downloadErrs pkg.DownloadErrors
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &downloadErrs):
+		return exitIncomplete
+	case errors.Is(err, pkg.ErrNotFound):
+		return exitNotFound
+	case errors.Is(err, pkg.ErrOverBudget):
+		return exitOverBudget
+	}
+	return exitFailure
+}
+
+var subcommands = map[string]func(){
+	"feed":    feedMain,
+	"history": historyMain,
+	"serve":   serveMain,
+	"watch":   watchMain,
+}
+
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	if len(os.Args) > 1 {
+		if subcommand, ok := subcommands[os.Args[1]]; ok {
+			os.Args = append([]string{os.Args[0] + " " + os.Args[1]}, os.Args[2:]...)
+			subcommand()
+			return
+		}
+	}
+	logger := cmd.DefaultLogger()
 	flag.Usage = cmd.PrintUsage
+	logFlags := cmd.DefineLogFlags()
 	urlFlag := flag.String("url", "", "URL to download")
 	noDownloadImageFlag := flag.Bool("no-download-image", false, "Don't download images. Default: false")
 	noDownloadTrackFlag := flag.Bool("no-download-track", false, "Don't download tracks. Default: false")
@@ -59,17 +293,74 @@ func main() {
 	noCreateAlbumInfoFlag := flag.Bool("no-create-album-info", false, "Don't create info.json. Default: false")
 	noCreateWindowsShortcutFlag := flag.Bool("no-create-windows-shortcut", false, "Don't create Windows shortcut. Default: false")
 	fixTags := flag.Bool("fix-tags", false, "Fix tags of the downloaded files. Default: false")
+	fromInfoFlag := flag.String("from-info", "", "Download using the URLs recorded in an info.json instead of scraping the album. Pages are only scraped again for URLs that are gone")
+	fromPlanFlag := flag.String("from-plan", "", "Download the files of a plan saved from -plan. Pages are only scraped again for URLs that are gone")
+	planFlag := flag.Bool("plan", false, "Print the files that would be downloaded as JSON, without writing anything to disk. Default: false")
 	overwriteFlag := flag.Bool("overwrite", false, "Redownload existing files. This option does not affect generation of info.json and link. Default: false")
 	trackFlag := trackFlags{}
-	flag.Var(&trackFlag, "track", "Tracks to download. Format: [disc number-]track number. Example: -track 1-1,1-2. Special value '*' means all tracks. Default to all tracks.")
+	flag.Var(&trackFlag, "track", "Tracks to download, as comma separated [disc:]track numbers or ranges, ~text contained in names, /regexp/ matching names, or any of these after ! to exclude tracks (example: -track 1:3-12,2:*,!~remix). Special value '*' means all tracks. Default to all tracks.")
 	trackFormatPreferenceFlag := formatPreferenceFlags{}
-	flag.Var(&trackFormatPreferenceFlag, "track-format-preference", "File format preference. If available, files with types in the left of this list will be downloaded. Default to 'FLAC,MP3,OGG,*'")
-	flag.Parse()
-	if *urlFlag == "" {
+	flag.Var(&trackFormatPreferenceFlag, "track-format-preference", "File format preference. If available, files with types in the left of this list will be downloaded. Besides format names, LOSSLESS, LOSSY, LARGEST, SMALLEST and HIGHEST_BITRATE choose by quality, probing file headers if needed (example: -track-format-preference FLAC,LOSSY). Default to 'FLAC,MP3,OGG,*'")
+	interactiveFlag := flag.Bool("interactive", false, "List the tracks of the album and their formats, and ask which tracks and formats to download instead of -track and -track-format-preference. Default: false")
+	formatsFlag := formatPreferenceFlags{}
+	flag.Var(&formatsFlag, "formats", "Download each of these file formats into its own subfolder named after the format, instead of one format chosen by -track-format-preference (example: -formats FLAC,MP3). Default: none")
+	var maxSizeFlag byteSizeFlag
+	flag.Var(&maxSizeFlag, "max-size", "Maximum total size of the files to download (example: -max-size 2GB). The download is also limited by the free disk space. Default: unlimited")
+	downgradeFlag := flag.Bool("downgrade-over-budget", false, "Choose smaller formats for the largest tracks instead of aborting when the download exceeds -max-size or the free disk space. Default: false")
+	outputArchiveFlag := flag.String("output-archive", "", "Download into an archive named after the album folder instead of the folder: zip, tar or tar.zst. Existing files are not checked and nothing is resumed. Default: none, download into a folder")
+	joinFormatsFlag := cmd.KeySetFlags{}
+	flag.Var(&joinFormatsFlag, "join-multi-values", "Used with -fix-tags. File formats for which multiple values of a tag are joined with '; ' into a single value, for players that only show the first value (example: -join-multi-values MP3,M4A). Special value '*' means all formats. Default: none, write multiple values")
+	clientFlags := defineClientFlags()
+	pruneCacheFlag := flag.Bool("prune-cache", false, "Remove cached pages older than -cache-ttl and exit. Use -cache-ttl 0 to remove all. Default: false")
+	historyFileFlag := flag.String("history-file", "", "File recording the albums downloaded. Default: soundtrack-downloader/history.jsonl in the user config directory")
+	noHistoryFlag := flag.Bool("no-history", false, "Don't read or record the download history. Default: false")
+	skipDownloadedFlag := flag.Bool("skip-downloaded", false, "Skip albums completely downloaded before according to the history, with all their tracks and images, even if their folder was moved, instead of only warning. Default: false")
+	if err := cmd.ParseFlags("downloader"); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger, logFile, err := logFlags.NewLogger()
+	if err != nil {
+		cmd.DefaultLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	if *historyFileFlag == "" {
+		*historyFileFlag = pkg.DefaultHistoryPath()
+	}
+	if *pruneCacheFlag {
+		dir, err := clientFlags.cacheDir()
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		removed, err := pkg.PruneHttpCache(dir, *clientFlags.cacheTtl)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("pruned cache", "dir", dir, "removed", removed)
+		return
+	}
+	if *urlFlag == "" && *fromInfoFlag == "" && *fromPlanFlag == "" {
 		flag.Usage()
 		logger.Error("url is required")
 		os.Exit(1)
 	}
+	if *outputArchiveFlag != "" {
+		if !slices.Contains(pkg.ArchiveFormats, *outputArchiveFlag) {
+			logger.Error("invalid output-archive: " + *outputArchiveFlag)
+			os.Exit(1)
+		}
+		if *fromInfoFlag != "" || *fromPlanFlag != "" {
+			logger.Error("output-archive can only be used with url")
+			os.Exit(1)
+		}
+		if *fixTags {
+			logger.Error("fix-tags can't be used with output-archive")
+			os.Exit(1)
+		}
+	}
 	if *noDownloadFlag {
 		*noDownloadImageFlag = true
 		*noDownloadTrackFlag = true
@@ -88,6 +379,17 @@ func main() {
 	} else if *noDownloadTrackFlag {
 		logger.Warn("specifying track while no-download-track is set has no effect")
 	}
+	if err := pkg.ValidateFormats(formatsFlag); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if err := pkg.ValidateTrackFormatRanking(trackFormatPreferenceFlag); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	if len(formatsFlag) > 0 && len(trackFormatPreferenceFlag) > 0 {
+		logger.Warn("specifying track-format-preference while formats is set has no effect")
+	}
 	if len(trackFormatPreferenceFlag) == 0 {
 		default_ := pkg.TrackFormatRanking{"FLAC", "MP3", "OGG", "*"}
 		trackFormatPreferenceFlag = formatPreferenceFlags(default_)
@@ -95,17 +397,118 @@ func main() {
 		logger.Warn("specifying track-format-preference while no-download-track is set has no effect")
 	}
 
-	info, folder, err := pkg.FetchAlbum(context.Background(), http.DefaultClient, logger, ".", *urlFlag, *noDownloadImageFlag, *noDownloadTrackFlag, *noCreateAlbumInfoFlag, *noCreateWindowsShortcutFlag, *overwriteFlag, pkg.TrackNumberSet(trackFlag), pkg.TrackFormatRanking(trackFormatPreferenceFlag))
+	client, err := clientFlags.newClient(logger)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
-	if *fixTags {
-		logger.Info("fixing tags")
-		err := pkg.FixTags(logger, pkg.AlbumInfoToTags(info), pkg.AlbumInfoToFileTags(info), nil, folder, false, false, false)
+
+	// Files being downloaded are kept as .part to be resumed by the next run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *interactiveFlag {
+		if *fromPlanFlag != "" {
+			logger.Error("interactive can't be used with from-plan")
+			os.Exit(1)
+		}
+		info := &pkg.AlbumInfo{}
+		if *fromInfoFlag != "" {
+			err = readJSONFile(*fromInfoFlag, info)
+		} else {
+			info, err = pkg.FetchAlbumInfo(ctx, client, *urlFlag)
+		}
 		if err != nil {
 			logger.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		tracks, ranking, err := pickTracks(os.Stdin, os.Stderr, info, pkg.TrackFormatRanking(trackFormatPreferenceFlag))
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		trackFlag, trackFormatPreferenceFlag = trackFlags(tracks), formatPreferenceFlags(ranking)
+	}
+
+	if *planFlag {
+		if *urlFlag == "" {
+			logger.Error("plan requires url")
 			os.Exit(1)
 		}
+		plan, err := pkg.PlanAlbum(ctx, client, logger, ".", *urlFlag, *noDownloadImageFlag, *noDownloadTrackFlag, *overwriteFlag, pkg.TrackNumberSet(trackFlag), pkg.TrackFormatRanking(trackFormatPreferenceFlag), []string(formatsFlag), int64(maxSizeFlag), *downgradeFlag)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(exitCode(err))
+		}
+		if err := pkg.WriteDownloadPlanJSON(os.Stdout, plan); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	var info *pkg.AlbumInfo
+	var savedPlan *pkg.DownloadPlan
+	albumUrl := *urlFlag
+	if *fromInfoFlag != "" {
+		info = &pkg.AlbumInfo{}
+		if err := readJSONFile(*fromInfoFlag, info); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		albumUrl = info.Url
+	} else if *fromPlanFlag != "" {
+		savedPlan = &pkg.DownloadPlan{}
+		if err := readJSONFile(*fromPlanFlag, savedPlan); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		if savedPlan.Album != nil {
+			albumUrl = savedPlan.Album.Url
+		}
+	}
+
+	var history []pkg.HistoryEntry
+	if !*noHistoryFlag {
+		if history, err = pkg.LoadHistory(*historyFileFlag); err != nil {
+			logger.Warn("failed to read history: " + err.Error())
+		}
+	}
+	if last := pkg.LastDownload(history, albumUrl); last != nil {
+		if *skipDownloadedFlag && last.Complete {
+			logger.Info("skipped album as it was already downloaded", "time", last.Time, "folder", last.Folder)
+			return
+		}
+		logger.Warn("album was already downloaded", "time", last.Time, "folder", last.Folder, "status", last.Status)
+	}
+
+	var plan *pkg.DownloadPlan
+	if info != nil {
+		plan, err = pkg.FetchAlbumFromInfo(ctx, client, logger, pkg.AlbumFolder(".", info), info, *noDownloadImageFlag, *noDownloadTrackFlag, *noCreateAlbumInfoFlag, *noCreateWindowsShortcutFlag, *overwriteFlag, pkg.TrackNumberSet(trackFlag), pkg.TrackFormatRanking(trackFormatPreferenceFlag), []string(formatsFlag), int64(maxSizeFlag), *downgradeFlag)
+	} else if savedPlan != nil {
+		plan, err = pkg.FetchAlbumFromPlan(ctx, client, logger, savedPlan, *noCreateAlbumInfoFlag, *noCreateWindowsShortcutFlag, *overwriteFlag, int64(maxSizeFlag), *downgradeFlag)
+	} else if *outputArchiveFlag != "" {
+		plan, err = pkg.FetchAlbumToArchive(ctx, client, logger, ".", *urlFlag, *outputArchiveFlag, *noDownloadImageFlag, *noDownloadTrackFlag, *noCreateAlbumInfoFlag, *noCreateWindowsShortcutFlag, pkg.TrackNumberSet(trackFlag), pkg.TrackFormatRanking(trackFormatPreferenceFlag), []string(formatsFlag), int64(maxSizeFlag), *downgradeFlag)
+	} else {
+		plan, err = pkg.FetchAlbum(ctx, client, logger, ".", *urlFlag, *noDownloadImageFlag, *noDownloadTrackFlag, *noCreateAlbumInfoFlag, *noCreateWindowsShortcutFlag, *overwriteFlag, pkg.TrackNumberSet(trackFlag), pkg.TrackFormatRanking(trackFormatPreferenceFlag), []string(formatsFlag), int64(maxSizeFlag), *downgradeFlag)
+	}
+	if plan != nil && !*noHistoryFlag {
+		recordHistory(logger, *historyFileFlag, plan)
+	}
+	// Tags are still fixed for the files that were downloaded
+	var downloadErrs pkg.DownloadErrors
+	if err != nil && !errors.As(err, &downloadErrs) {
+		logger.Error(err.Error())
+		os.Exit(exitCode(err))
+	}
+	if *fixTags {
+		if err := fixAlbumTags(logger, plan, pkg.InsStringKeySet(joinFormatsFlag)); err != nil {
+			logger.Error(err.Error())
+			os.Exit(exitFailure)
+		}
+	}
+	if len(downloadErrs) > 0 {
+		logger.Error("some files failed to download", "failed", len(downloadErrs))
+		os.Exit(exitIncomplete)
 	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+var errPickerAborted = errors.New("interactive selection aborted")
+
+func trackFormats(t *pkg.TrackInfo) []string {
+	formats := map[string]struct{}{}
+	for format := range t.Sizes {
+		formats[strings.ToUpper(format)] = struct{}{}
+	}
+	for format := range t.SongUrl {
+		formats[strings.ToUpper(format)] = struct{}{}
+	}
+	return slices.Sorted(maps.Keys(formats))
+}
+
+// Parses 1-based row numbers and ranges such as 1-3,5
+func parseRows(input string, count int) ([]int, error) {
+	var rows []int
+	for part := range strings.SplitSeq(input, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		end := start
+		if err == nil && isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(to))
+		}
+		if err != nil || start < 1 || end > count || start > end {
+			return nil, fmt.Errorf("invalid row: %s, expected numbers from 1 to %d", part, count)
+		}
+		for i := start; i <= end; i++ {
+			rows = append(rows, i)
+		}
+	}
+	return rows, nil
+}
+
+func printTrackRows(w io.Writer, tracks []pkg.TrackInfo, selected []bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "\t#\tDisc\tTrack\tName\tDuration\tFormats\n")
+	for i := range tracks {
+		t := &tracks[i]
+		mark := "[ ]"
+		if selected[i] {
+			mark = "[x]"
+		}
+		var formats []string
+		for _, format := range trackFormats(t) {
+			if size, ok := t.Sizes[format]; ok {
+				format += " " + pkg.FormatByteSize(size)
+			}
+			formats = append(formats, format)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", mark, i+1, t.DiscNumber, t.TrackNumber, t.Name, t.Duration, strings.Join(formats, ", "))
+	}
+	tw.Flush()
+}
+
+// Lets the user tick tracks of the album and choose the format preference, and returns them as
+// the -track and -track-format-preference flags would. The ranking is kept if no format is chosen
+func pickTracks(r io.Reader, w io.Writer, info *pkg.AlbumInfo, ranking pkg.TrackFormatRanking) (pkg.TrackNumberSet, pkg.TrackFormatRanking, error) {
+	scanner := bufio.NewScanner(r)
+	prompt := func(text string) (string, error) {
+		fmt.Fprint(w, text)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", err
+			}
+			return "", errPickerAborted
+		}
+		return strings.TrimSpace(scanner.Text()), nil
+	}
+
+	selected := make([]bool, len(info.Tracks))
+	for i := range selected {
+		selected[i] = true
+	}
+	fmt.Fprintln(w, info.Name)
+	for {
+		printTrackRows(w, info.Tracks, selected)
+		line, err := prompt("Toggle tracks by row (e.g. 1-3,5), a for all, n for none, enter to continue: ")
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == "" {
+			if slices.Contains(selected, true) {
+				break
+			}
+			fmt.Fprintln(w, "No track selected")
+			continue
+		}
+		if line == "a" || line == "n" {
+			for i := range selected {
+				selected[i] = line == "a"
+			}
+			continue
+		}
+		rows, err := parseRows(line, len(selected))
+		if err != nil {
+			fmt.Fprintln(w, err)
+			continue
+		}
+		for _, row := range rows {
+			selected[row-1] = !selected[row-1]
+		}
+	}
+
+	// Numbers may be missing or not numeric, so tracks are chosen by page
+	tracks := pkg.DownloadAllTracks
+	if slices.Contains(selected, false) {
+		tracks = pkg.TrackNumberSet{}
+		for i := range info.Tracks {
+			if !selected[i] {
+				continue
+			}
+			if info.Tracks[i].PageUrl == "" {
+				return nil, nil, fmt.Errorf("track %s has no page url to select it by", info.Tracks[i].Name)
+			}
+			tracks.AddTrack(&info.Tracks[i])
+		}
+	}
+
+	var formats []string
+	for i := range info.Tracks {
+		if selected[i] {
+			formats = append(formats, trackFormats(&info.Tracks[i])...)
+		}
+	}
+	slices.Sort(formats)
+	formats = slices.Compact(formats)
+	if len(formats) == 0 {
+		return tracks, ranking, nil
+	}
+	for i, format := range formats {
+		fmt.Fprintf(w, "%d) %s  ", i+1, format)
+	}
+	fmt.Fprintln(w)
+	for {
+		line, err := prompt(fmt.Sprintf("Formats by preference (e.g. 1 or 2,1), enter for %s: ", strings.Join(ranking, ",")))
+		if err != nil {
+			return nil, nil, err
+		}
+		if line == "" {
+			return tracks, ranking, nil
+		}
+		rows, err := parseRows(line, len(formats))
+		if err != nil {
+			fmt.Fprintln(w, err)
+			continue
+		}
+		chosen := pkg.TrackFormatRanking{}
+		for _, row := range rows {
+			chosen = append(chosen, formats[row-1])
+		}
+		// Tracks missing the chosen formats are still downloaded in another one
+		return tracks, append(chosen, "*"), nil
+	}
+}
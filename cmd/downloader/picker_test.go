@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+func TestParseRows(t *testing.T) {
+	rows, err := parseRows("1-3, 5,", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := []int{1, 2, 3, 5}; !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected %v, got %v", expected, rows)
+	}
+	for _, input := range []string{"0", "6", "3-1", "x", "1-"} {
+		if _, err := parseRows(input, 5); err == nil {
+			t.Fatalf("expected error for %s, got nil", input)
+		}
+	}
+}
+
+func TestPickTracks(t *testing.T) {
+	info := &pkg.AlbumInfo{
+		Name: "My Album 1",
+		Tracks: []pkg.TrackInfo{
+			{Name: "song1", DiscNumber: "1", TrackNumber: "01", Duration: "4:27", PageUrl: "https://example.com/1", Sizes: map[string]int64{"FLAC": 20 << 20, "MP3": 5 << 20}},
+			{Name: "song2", DiscNumber: "1", TrackNumber: "02", PageUrl: "https://example.com/2", Sizes: map[string]int64{"MP3": 4 << 20}},
+			{Name: "song3", DiscNumber: "2", TrackNumber: "01", PageUrl: "https://example.com/3", Sizes: map[string]int64{"MP3": 4 << 20, "OGG": 3 << 20}},
+			{Name: "bonus", PageUrl: "https://example.com/4", Sizes: map[string]int64{"MP3": 4 << 20}},
+			{Name: "extra", TrackNumber: "A1", PageUrl: "https://example.com/5", Sizes: map[string]int64{"MP3": 4 << 20}},
+		},
+	}
+	defaultRanking := pkg.TrackFormatRanking{"FLAC", "MP3", "OGG", "*"}
+
+	t.Run("happy path toggle tracks and choose format", func(t *testing.T) {
+		var out strings.Builder
+		// Invalid input is asked again
+		tracks, ranking, err := pickTracks(strings.NewReader("2-5\n9\n3\n\n2\n"), &out, info, defaultRanking)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := "@https://example.com/1,@https://example.com/3"; tracks.String() != expected {
+			t.Fatalf("expected %v, got %v", expected, tracks)
+		}
+		// Formats of song2 are not offered
+		if expected := (pkg.TrackFormatRanking{"MP3", "*"}); !reflect.DeepEqual(ranking, expected) {
+			t.Fatalf("expected %v, got %v", expected, ranking)
+		}
+		if !strings.Contains(out.String(), "[x]  1  1     01     song1  4:27      FLAC 20.00 MB, MP3 5.00 MB") || !strings.Contains(out.String(), "invalid row: 9") {
+			t.Fatalf("unexpected output: %s", out.String())
+		}
+	})
+
+	t.Run("happy path defaults", func(t *testing.T) {
+		tracks, ranking, err := pickTracks(strings.NewReader("n\n\na\n\n\n"), io.Discard, info, defaultRanking)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(tracks, pkg.DownloadAllTracks) || !reflect.DeepEqual(ranking, defaultRanking) {
+			t.Fatalf("expected %v %v, got %v %v", pkg.DownloadAllTracks, defaultRanking, tracks, ranking)
+		}
+	})
+
+	t.Run("happy path tracks without usable numbers", func(t *testing.T) {
+		tracks, _, err := pickTracks(strings.NewReader("n\n4-5\n\n\n"), io.Discard, info, defaultRanking)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var names []string
+		for i := range info.Tracks {
+			if tracks.Contains(&info.Tracks[i]) {
+				names = append(names, info.Tracks[i].Name)
+			}
+		}
+		if expected := "bonus,extra"; strings.Join(names, ",") != expected {
+			t.Fatalf("expected %v, got %v", expected, names)
+		}
+	})
+
+	t.Run("track without page url", func(t *testing.T) {
+		info := &pkg.AlbumInfo{Tracks: []pkg.TrackInfo{{Name: "song1"}, {Name: "song2"}}}
+		if _, _, err := pickTracks(strings.NewReader("2\n\n"), io.Discard, info, defaultRanking); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+	})
+
+	t.Run("end of input", func(t *testing.T) {
+		if _, _, err := pickTracks(strings.NewReader("1\n"), io.Discard, info, defaultRanking); !errors.Is(err, errPickerAborted) {
+			t.Fatalf("expected %v, got %v", errPickerAborted, err)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cleoold/soundtrack-downloader/cmd"
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// Counts the bytes of response bodies read through the client
+type countingClient struct {
+	client pkg.HttpDoClient
+	add    func(n int)
+}
+
+func (c *countingClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err == nil {
+		resp.Body = &countingBody{resp.Body, c.add}
+	}
+	return resp, err
+}
+
+type countingBody struct {
+	io.ReadCloser
+	add func(n int)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.add(n)
+	return n, err
+}
+
+type serveOptions struct {
+	dir         string
+	maxSize     int64
+	historyFile string
+}
+
+func parseJobTracks(req pkg.JobRequest) (pkg.TrackNumberSet, error) {
+	if len(req.Tracks) == 0 {
+		return pkg.DownloadAllTracks, nil
+	}
+	tracks := trackFlags{}
+	if err := tracks.Set(strings.Join(req.Tracks, ",")); err != nil {
+		return nil, err
+	}
+	return pkg.TrackNumberSet(tracks), nil
+}
+
+// Downloads the album of a job like the downloader command does, planning first so that
+// the size of the download is known for the progress
+func runServeJob(ctx context.Context, client pkg.HttpDoClient, logger *slog.Logger, opts serveOptions, job pkg.Job, progress func(pkg.JobProgress)) (string, error) {
+	logger = logger.With("job", job.Id)
+	req := job.Request
+	tracks, err := parseJobTracks(req)
+	if err != nil {
+		return "", err
+	}
+	// Jobs saved by earlier versions were not validated when submitted
+	ranking := formatPreferenceFlags{"FLAC", "MP3", "OGG", "*"}
+	if len(req.TrackFormatPreference) > 0 {
+		ranking = formatPreferenceFlags{}
+		if err := ranking.Set(strings.Join(req.TrackFormatPreference, ",")); err != nil {
+			return "", err
+		}
+		if err := pkg.ValidateTrackFormatRanking(ranking); err != nil {
+			return "", err
+		}
+	}
+	formats := formatPreferenceFlags{}
+	if len(req.Formats) > 0 {
+		if err := formats.Set(strings.Join(req.Formats, ",")); err != nil {
+			return "", err
+		}
+	}
+	plan, err := pkg.PlanAlbum(ctx, client, logger, opts.dir, req.Url, req.NoDownloadImage, false, false, tracks, pkg.TrackFormatRanking(ranking), []string(formats), opts.maxSize, false)
+	if err != nil {
+		return "", err
+	}
+
+	var mu sync.Mutex
+	p := pkg.JobProgress{TotalSize: plan.TotalSize}
+	for _, item := range plan.Items {
+		if item.SkipReason == "" {
+			p.Files++
+		}
+	}
+	progress(p)
+	counting := &countingClient{client, func(n int) {
+		mu.Lock()
+		defer mu.Unlock()
+		p.Downloaded += int64(n)
+		progress(p)
+	}}
+	plan, err = pkg.FetchAlbumFromPlan(ctx, counting, logger, plan, false, false, false, opts.maxSize, false)
+	if plan == nil {
+		return "", err
+	}
+	if opts.historyFile != "" {
+		recordHistory(logger, opts.historyFile, plan)
+	}
+	if err == nil && req.FixTags {
+		err = fixAlbumTags(logger, plan, nil)
+	}
+	return plan.Folder, err
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct{ Error string }{err.Error()})
+}
+
+func jobErrorCode(err error) int {
+	switch {
+	case errors.Is(err, pkg.ErrJobNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, pkg.ErrJobFinished):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// REST API of the job queue, and the web page using it
+func newServeHandler(client pkg.HttpDoClient, queue *pkg.JobQueue) *http.ServeMux {
+	mux := http.NewServeMux()
+	web, _ := fs.Sub(webFiles, "web")
+	mux.Handle("GET /", http.FileServerFS(web))
+	mux.HandleFunc("GET /api/album", func(w http.ResponseWriter, r *http.Request) {
+		albumUrl := r.URL.Query().Get("url")
+		if albumUrl == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("url is required"))
+			return
+		}
+		info, err := pkg.FetchAlbumInfo(r.Context(), client, albumUrl)
+		if errors.Is(err, pkg.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	})
+	mux.HandleFunc("POST /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.JobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := parseJobTracks(req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := pkg.ValidateFormats(req.Formats); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := pkg.ValidateTrackFormatRanking(req.TrackFormatPreference); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		job, err := queue.Submit(req)
+		if errors.Is(err, pkg.ErrJobDuplicate) {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		} else if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, job)
+	})
+	mux.HandleFunc("GET /api/jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, queue.Jobs())
+	})
+	mux.HandleFunc("GET /api/jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		job, err := queue.Job(r.PathValue("id"))
+		if err != nil {
+			writeJSONError(w, jobErrorCode(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", func(w http.ResponseWriter, r *http.Request) {
+		job, err := queue.Cancel(r.PathValue("id"))
+		if err != nil {
+			writeJSONError(w, jobErrorCode(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+	})
+	return mux
+}
+
+// Runs a server downloading the albums submitted to its REST API or web page: downloader serve [flags]
+func serveMain() {
+	logger := cmd.DefaultLogger()
+	flag.Usage = cmd.PrintUsage
+	logFlags := cmd.DefineLogFlags()
+	listenFlag := flag.String("listen", "localhost:8080", "Address of the REST API and web page. Use :8080 to accept connections from other hosts")
+	workersFlag := flag.Int("workers", 2, "Number of albums downloaded at once")
+	dirFlag := flag.String("dir", ".", "Folder to download albums into")
+	jobsFileFlag := flag.String("jobs-file", "", "File keeping the jobs across restarts. Default: soundtrack-downloader/jobs.json in the user config directory")
+	var maxSizeFlag byteSizeFlag
+	flag.Var(&maxSizeFlag, "max-size", "Maximum total size of the files of each album (example: -max-size 2GB). Default: unlimited")
+	historyFileFlag := flag.String("history-file", "", "File recording the albums downloaded. Default: soundtrack-downloader/history.jsonl in the user config directory")
+	noHistoryFlag := flag.Bool("no-history", false, "Don't record the download history. Default: false")
+	clientFlags := defineClientFlags()
+	if err := cmd.ParseFlags("serve"); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger, logFile, err := logFlags.NewLogger()
+	if err != nil {
+		cmd.DefaultLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	opts := serveOptions{
+		dir:         *dirFlag,
+		maxSize:     int64(maxSizeFlag),
+		historyFile: *historyFileFlag,
+	}
+	if *noHistoryFlag {
+		opts.historyFile = ""
+	} else if opts.historyFile == "" {
+		opts.historyFile = pkg.DefaultHistoryPath()
+	}
+	if *jobsFileFlag == "" {
+		*jobsFileFlag = pkg.DefaultJobsPath()
+	}
+	client, err := clientFlags.newClient(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	run := func(ctx context.Context, job pkg.Job, progress func(pkg.JobProgress)) (string, error) {
+		return runServeJob(ctx, client, logger, opts, job, progress)
+	}
+	queue, err := pkg.OpenJobQueue(logger, *jobsFileFlag, *workersFlag, run)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Running jobs are left queued on exit, to be resumed from their .part files on the next start
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: *listenFlag, Handler: newServeHandler(client, queue)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+	logger.Info("listening on " + *listenFlag)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	<-done
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+func TestServeHandler(t *testing.T) {
+	jobsFile := filepath.Join(t.TempDir(), "jobs.json")
+	// Jobs stay queued as the queue is not run
+	queue, err := pkg.OpenJobQueue(slog.New(slog.DiscardHandler), jobsFile, 1, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	album, err := os.ReadFile("../../pkg/testdata/album1_home.html")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/my-album-1" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(album)
+	}))
+	defer site.Close()
+	handler := newServeHandler(site.Client(), queue)
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		rec := do("POST", "/api/jobs", `{"Url": "https://example.com/my-album-1", "Tracks": ["1:1-2"]}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected %v, got %v: %s", http.StatusCreated, rec.Code, rec.Body)
+		}
+		var job pkg.Job
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if job.State != pkg.JobQueued || job.Request.Url != "https://example.com/my-album-1" {
+			t.Fatalf("unexpected job: %v", job)
+		}
+
+		if rec = do("POST", "/api/jobs", `{"Url": "https://example.com/my-album-1"}`); rec.Code != http.StatusConflict {
+			t.Fatalf("expected %v, got %v", http.StatusConflict, rec.Code)
+		}
+
+		rec = do("GET", "/api/jobs", "")
+		var jobs []pkg.Job
+		if err := json.Unmarshal(rec.Body.Bytes(), &jobs); err != nil || len(jobs) != 1 || jobs[0].Id != job.Id {
+			t.Fatalf("unexpected jobs: %s", rec.Body)
+		}
+
+		rec = do("POST", "/api/jobs/"+job.Id+"/cancel", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &job); err != nil || job.State != pkg.JobCanceled {
+			t.Fatalf("unexpected job: %s", rec.Body)
+		}
+		if rec = do("POST", "/api/jobs/"+job.Id+"/cancel", ""); rec.Code != http.StatusConflict {
+			t.Fatalf("expected %v, got %v", http.StatusConflict, rec.Code)
+		}
+
+		// Saved for the next start
+		reopened, err := pkg.OpenJobQueue(slog.New(slog.DiscardHandler), jobsFile, 1, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if saved, err := reopened.Job(job.Id); err != nil || saved.State != pkg.JobCanceled {
+			t.Fatalf("expected %v, got %v %v", pkg.JobCanceled, saved.State, err)
+		}
+	})
+
+	t.Run("happy path web page and album preview", func(t *testing.T) {
+		rec := do("GET", "/", "")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "app.js") {
+			t.Fatalf("unexpected page: %v %s", rec.Code, rec.Body)
+		}
+		rec = do("GET", "/api/album?url="+url.QueryEscape(site.URL+"/my-album-1"), "")
+		var info pkg.AlbumInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil || info.Name == "" || len(info.Tracks) == 0 {
+			t.Fatalf("unexpected album: %v %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("invalid requests", func(t *testing.T) {
+		tests := []struct {
+			method, target, body string
+			expected             int
+		}{
+			{"POST", "/api/jobs", `{"Url": ""}`, http.StatusBadRequest},
+			{"POST", "/api/jobs", `{"Url": "https://example.com/my-album-1", "Tracks": ["1-2-3"]}`, http.StatusBadRequest},
+			{"POST", "/api/jobs", `{"Url": "https://example.com/my-album-1", "Formats": ["*"]}`, http.StatusBadRequest},
+			{"POST", "/api/jobs", `{"Url": "https://example.com/my-album-1", "TrackFormatPreference": ["FLAC MP3"]}`, http.StatusBadRequest},
+			{"POST", "/api/jobs", `not json`, http.StatusBadRequest},
+			{"GET", "/api/jobs/unknown", "", http.StatusNotFound},
+			{"GET", "/api/album", "", http.StatusBadRequest},
+			{"GET", "/api/album?url=" + url.QueryEscape(site.URL+"/unknown"), "", http.StatusNotFound},
+			{"POST", "/api/jobs/unknown/cancel", "", http.StatusNotFound},
+		}
+		for _, tt := range tests {
+			if rec := do(tt.method, tt.target, tt.body); rec.Code != tt.expected {
+				t.Fatalf("expected %v for %s %s, got %v", tt.expected, tt.method, tt.target, rec.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+
+	"github.com/cleoold/soundtrack-downloader/cmd"
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+type watchOptions struct {
+	dir                string
+	download           bool
+	fixTags            bool
+	trackFormatRanking pkg.TrackFormatRanking
+	historyFile        string
+}
+
+// Checks one watched album and downloads its new tracks and images if enabled
+func watchAlbum(ctx context.Context, client pkg.HttpDoClient, logger *slog.Logger, opts watchOptions, albumUrl string) error {
+	var history []pkg.HistoryEntry
+	if opts.historyFile != "" {
+		var err error
+		if history, err = pkg.LoadHistory(opts.historyFile); err != nil {
+			logger.Warn("failed to read history: " + err.Error())
+		}
+	}
+	if last := pkg.LastDownload(history, albumUrl); last != nil && last.Archive {
+		logger.Warn("skipped album downloaded into an archive", "archive", last.Folder)
+		return nil
+	}
+	check, err := pkg.CheckAlbum(ctx, client, history, opts.dir, albumUrl)
+	if err != nil {
+		return err
+	}
+	changes := check.Changes
+	if changes.Empty() {
+		logger.Info("no changes", "album", check.Remote.Name)
+		return nil
+	}
+	for _, t := range changes.NewTracks {
+		logger.Info("new track", "album", check.Remote.Name, "disc", t.DiscNumber, "track", t.TrackNumber, "name", t.Name)
+	}
+	for _, imgInfo := range changes.NewImages {
+		logger.Info("new image", "album", check.Remote.Name, "url", imgInfo.ImageUrl)
+	}
+	for _, t := range changes.RemovedTracks {
+		logger.Warn("track removed from album page", "album", check.Remote.Name, "disc", t.DiscNumber, "track", t.TrackNumber, "name", t.Name)
+	}
+	if !opts.download || (len(changes.NewTracks) == 0 && len(changes.NewImages) == 0) {
+		return nil
+	}
+
+	pkg.MergeTrackDownloads(check.Local, check.Remote)
+	// Numbers may be missing or not numeric, so tracks are chosen by page
+	trackNumberSet := pkg.TrackNumberSet{}
+	for i := range changes.NewTracks {
+		trackNumberSet.AddTrack(&changes.NewTracks[i])
+	}
+	// Keep downloading the formats of the earlier download, into its folder even if renamed
+	formats := slices.Sorted(maps.Keys(check.Local.FormatFolders))
+	plan, err := pkg.FetchAlbumFromInfo(ctx, client, logger, check.Folder, check.Remote, len(changes.NewImages) == 0, len(changes.NewTracks) == 0, false, false, false, trackNumberSet, opts.trackFormatRanking, formats, 0, false)
+	if plan != nil && opts.historyFile != "" {
+		recordHistory(logger, opts.historyFile, plan)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.fixTags {
+		return fixAlbumTags(logger, plan, nil)
+	}
+	return nil
+}
+
+func readWatchList(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return pkg.ReadWatchList(f)
+}
+
+// Re-checks watched albums for new tracks and images: downloader watch [flags]
+func watchMain() {
+	logger := cmd.DefaultLogger()
+	flag.Usage = cmd.PrintUsage
+	logFlags := cmd.DefineLogFlags()
+	listFlag := flag.String("list", "", "File of album URLs to watch, one per line. Lines starting with # are comments")
+	intervalFlag := flag.Duration("interval", 0, "Keep running and check again after this duration (example: -interval 6h). Default: 0, check once, e.g. from cron")
+	downloadFlag := flag.Bool("download", false, "Download new tracks and images instead of only reporting them. Default: false")
+	dirFlag := flag.String("dir", ".", "Folder containing albums not found in the history, where they are downloaded into")
+	fixTags := flag.Bool("fix-tags", false, "Fix tags of albums with new files downloaded. Default: false")
+	trackFormatPreferenceFlag := formatPreferenceFlags{}
+	flag.Var(&trackFormatPreferenceFlag, "track-format-preference", "File format preference of new tracks, as for downloading. Albums downloaded with -formats keep their formats. Default to 'FLAC,MP3,OGG,*'")
+	historyFileFlag := flag.String("history-file", "", "File recording the albums downloaded, used to find the folders of albums. Default: soundtrack-downloader/history.jsonl in the user config directory")
+	noHistoryFlag := flag.Bool("no-history", false, "Don't read or record the download history. Default: false")
+	clientFlags := defineClientFlags()
+	if err := cmd.ParseFlags("watch"); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger, logFile, err := logFlags.NewLogger()
+	if err != nil {
+		cmd.DefaultLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
+	if *listFlag == "" {
+		flag.Usage()
+		logger.Error("list is required")
+		os.Exit(1)
+	}
+	if len(trackFormatPreferenceFlag) == 0 {
+		trackFormatPreferenceFlag = formatPreferenceFlags{"FLAC", "MP3", "OGG", "*"}
+	}
+	opts := watchOptions{
+		dir:                *dirFlag,
+		download:           *downloadFlag,
+		fixTags:            *fixTags,
+		trackFormatRanking: pkg.TrackFormatRanking(trackFormatPreferenceFlag),
+		historyFile:        *historyFileFlag,
+	}
+	if *noHistoryFlag {
+		opts.historyFile = ""
+	} else if opts.historyFile == "" {
+		opts.historyFile = pkg.DefaultHistoryPath()
+	}
+	client, err := clientFlags.newClient(logger)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	failed := false
+	runEvery(ctx, *intervalFlag, func() {
+		// Read on each round so that the list can be edited while running, and fixed if broken
+		urls, err := readWatchList(*listFlag)
+		if err != nil {
+			logger.Error(err.Error())
+			if *intervalFlag <= 0 {
+				os.Exit(1)
+			}
+			failed = true
+			return
+		}
+		for _, albumUrl := range urls {
+			if ctx.Err() != nil {
+				break
+			}
+			logger.Info("checking " + albumUrl)
+			if err := watchAlbum(ctx, client, logger, opts, albumUrl); err != nil && ctx.Err() == nil {
+				logger.Error(err.Error(), "url", albumUrl)
+				failed = true
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		logger.Error("interrupted")
+		os.Exit(exitInterrupted)
+	}
+	if failed {
+		os.Exit(exitFailure)
+	}
+}
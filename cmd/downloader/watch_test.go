@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+func TestWatchAlbum(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	song1 := readTestdata(t, "song1.html")
+	local := pkg.AlbumInfo{
+		Name: "My Album 1",
+		Url:  "https://example.com/my-album-1",
+		Tracks: []pkg.TrackInfo{
+			{Name: "song1", TrackNumber: "1", PageUrl: "https://example.com/01.%2520song1.mp3", SongUrl: map[string]string{"FLAC": "https://download.com/01.%20song1.flac"}},
+		},
+		Images: []pkg.ImageInfo{{ImageUrl: "https://download.com/Cover.jpg"}},
+	}
+
+	// song1 was left out of the earlier download, and song2 is new with an unusual number
+	for _, trackNumber := range []string{"", "A1"} {
+		t.Run("happy path downloads only the new track numbered "+trackNumber, func(t *testing.T) {
+			home := strings.Replace(readTestdata(t, "album1_home.html"), "<td>2.</td>", "<td>"+trackNumber+"</td>", 1)
+			client := stubClient{
+				"https://example.com/my-album-1":        {"GET": {http.StatusOK, home}},
+				"https://example.com/01.%2520song1.mp3": {"GET": {http.StatusOK, song1}},
+				"https://example.com/01.%2520song2.mp3": {"GET": {http.StatusOK, strings.ReplaceAll(strings.ReplaceAll(song1, "song1", "song2"), "01", "02")}},
+				"https://download.com/01.%20song1.flac": {"GET": {http.StatusOK, "content of song1"}},
+				"https://download.com/02.%20song2.flac": {"GET": {http.StatusOK, "content of song2"}},
+			}
+			dir := t.TempDir()
+			folder := filepath.Join(dir, "My Album 1")
+			if err := os.Mkdir(folder, 0755); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			data, _ := json.Marshal(local)
+			if err := os.WriteFile(filepath.Join(folder, "info.json"), data, 0644); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			opts := watchOptions{dir: dir, download: true, trackFormatRanking: pkg.TrackFormatRanking{"FLAC"}}
+			if err := watchAlbum(context.Background(), client, logger, opts, "https://example.com/my-album-1"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if content, err := os.ReadFile(filepath.Join(folder, "02. song2.flac")); err != nil || string(content) != "content of song2" {
+				t.Fatalf("expected %v, got %v %v", "content of song2", string(content), err)
+			}
+			if _, err := os.Stat(filepath.Join(folder, "01. song1.flac")); err == nil {
+				t.Fatalf("expected song1 not to be downloaded")
+			}
+		})
+	}
+
+	t.Run("skips albums downloaded into an archive", func(t *testing.T) {
+		// Nothing is fetched, as the stub client has no pages
+		dir := t.TempDir()
+		opts := watchOptions{dir: dir, download: true, historyFile: filepath.Join(dir, "history.jsonl")}
+		entry := pkg.HistoryEntry{Url: "https://example.com/my-album-1", Folder: filepath.Join(dir, "My Album 1.zip"), Archive: true, Status: pkg.StatusComplete}
+		if err := pkg.AppendHistory(opts.historyFile, entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := watchAlbum(context.Background(), stubClient{}, logger, opts, "https://example.com/my-album-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "My Album 1")); err == nil {
+			t.Fatalf("expected no album folder to be created")
+		}
+	})
+}
@@ -0,0 +1,179 @@
+"use strict";
+
+const $ = (id) => document.getElementById(id);
+
+let album = null;
+
+function el(tag, props = {}, children = []) {
+  const e = Object.assign(document.createElement(tag), props);
+  e.append(...children);
+  return e;
+}
+
+function showMessage(text, isError) {
+  $("message").textContent = text;
+  $("message").className = isError ? "error" : "";
+}
+
+async function api(method, path, body) {
+  const resp = await fetch(path, {
+    method,
+    headers: body ? { "Content-Type": "application/json" } : {},
+    body: body ? JSON.stringify(body) : undefined,
+  });
+  const data = await resp.json();
+  if (!resp.ok) {
+    throw new Error(data.Error || resp.statusText);
+  }
+  return data;
+}
+
+function formatSize(bytes) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (bytes >= 1024 && i < units.length - 1) {
+    bytes /= 1024;
+    i++;
+  }
+  return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+}
+
+// Formats listed for any track
+function albumFormats(info) {
+  const formats = new Set();
+  for (const track of info.Tracks || []) {
+    Object.keys(track.Sizes || {}).forEach((f) => formats.add(f));
+  }
+  return [...formats];
+}
+
+function trackKey(track) {
+  return track.DiscNumber ? track.DiscNumber + ":" + track.TrackNumber : track.TrackNumber;
+}
+
+function renderAlbum(info) {
+  const thumb = (info.Images || []).find((img) => img.ThumbUrl);
+  $("cover").hidden = !thumb;
+  $("cover").src = thumb ? thumb.ThumbUrl : "";
+  $("album-name").textContent = info.Name;
+  const details = [info.Platforms, info.Year, info.AlbumType].map((v) => (v || []).join(", ")).filter((v) => v);
+  details.push((info.Tracks || []).length + " tracks");
+  $("album-details").textContent = details.join(" · ");
+
+  $("formats").replaceChildren(
+    ...albumFormats(info).map((f) => el("label", {}, [el("input", { type: "checkbox", value: f }), " " + f])),
+  );
+  $("all-tracks").checked = true;
+  $("tracks").replaceChildren(
+    ...(info.Tracks || []).map((track) =>
+      el("tr", {}, [
+        el("td", {}, [el("input", { type: "checkbox", checked: true, value: trackKey(track) })]),
+        el("td", { textContent: track.DiscNumber || "" }),
+        el("td", { textContent: track.TrackNumber }),
+        el("td", { textContent: track.Name }),
+        el("td", { textContent: track.Duration || "" }),
+        el("td", {
+          textContent: Object.entries(track.Sizes || {})
+            .map(([f, size]) => f + " " + formatSize(size))
+            .join(", "),
+        }),
+      ]),
+    ),
+  );
+  $("album").hidden = false;
+}
+
+function renderJobs(jobs) {
+  $("jobs").replaceChildren(
+    ...jobs.reverse().map((job) => {
+      const p = job.Progress || {};
+      const progress = el("td");
+      if (job.State === "running" && p.TotalSize) {
+        progress.append(
+          el("progress", { max: p.TotalSize, value: Math.min(p.Downloaded || 0, p.TotalSize) }),
+          formatSize(p.Downloaded || 0) + " / " + formatSize(p.TotalSize),
+        );
+      } else if (job.Error) {
+        progress.append(el("span", { className: "error", textContent: job.Error }));
+      }
+      const actions = el("td");
+      if (job.State === "queued" || job.State === "running") {
+        actions.append(el("button", { textContent: "Cancel", onclick: () => cancelJob(job.Id) }));
+      }
+      return el("tr", {}, [
+        el("td", { textContent: job.Folder || job.Request.Url }),
+        el("td", { textContent: job.State }),
+        progress,
+        actions,
+      ]);
+    }),
+  );
+}
+
+async function refreshJobs() {
+  try {
+    renderJobs(await api("GET", "/api/jobs"));
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+}
+
+async function cancelJob(id) {
+  try {
+    await api("POST", "/api/jobs/" + encodeURIComponent(id) + "/cancel");
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+  refreshJobs();
+}
+
+$("preview-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  showMessage("Loading…");
+  $("album").hidden = true;
+  try {
+    album = await api("GET", "/api/album?url=" + encodeURIComponent($("url").value));
+    renderAlbum(album);
+    showMessage("");
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+});
+
+$("all-tracks").addEventListener("change", (event) => {
+  $("tracks").querySelectorAll("input").forEach((input) => (input.checked = event.target.checked));
+});
+
+$("job-form").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const boxes = [...$("tracks").querySelectorAll("input")];
+  const tracks = boxes.filter((input) => input.checked).map((input) => input.value);
+  if (tracks.length === 0) {
+    showMessage("Select at least one track", true);
+    return;
+  }
+  const formats = [...$("formats").querySelectorAll("input:checked")].map((input) => input.value);
+  const req = {
+    Url: album.Url,
+    FixTags: $("fix-tags").checked,
+    NoDownloadImage: $("no-images").checked,
+  };
+  if (tracks.length < boxes.length) {
+    req.Tracks = tracks;
+  }
+  if (formats.length === 1) {
+    req.TrackFormatPreference = [formats[0], "*"];
+  } else if (formats.length > 1) {
+    req.Formats = formats;
+  }
+  try {
+    await api("POST", "/api/jobs", req);
+    showMessage("Added " + album.Name);
+  } catch (err) {
+    showMessage(err.message, true);
+  }
+  refreshJobs();
+});
+
+refreshJobs();
+setInterval(refreshJobs, 2000);
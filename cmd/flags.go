@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cleoold/soundtrack-downloader/pkg"
+)
+
+// Comma separated keys such as tag names or file formats, ignoring case
+type KeySetFlags pkg.InsStringKeySet
+
+func (k KeySetFlags) String() string {
+	return fmt.Sprintf("%+v", pkg.InsStringKeySet(k))
+}
+
+func (k KeySetFlags) Set(value string) error {
+	for part := range strings.SplitSeq(value, ",") {
+		pkg.InsStringKeySet(k).Add(strings.TrimSpace(part))
+	}
+	return nil
+}
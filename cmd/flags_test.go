@@ -0,0 +1,21 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeySetFlags(t *testing.T) {
+	t.Run("Happy path", func(t *testing.T) {
+		s := KeySetFlags{}
+		if err := s.Set("ALBUM,artist,TRACKNUMBER"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		vacuum := struct{}{}
+		expected := KeySetFlags{"ALBUM": vacuum, "ARTIST": vacuum, "TRACKNUMBER": vacuum}
+		if !reflect.DeepEqual(s, expected) {
+			t.Fatalf("expected %v, got %v", expected, s)
+		}
+	})
+}
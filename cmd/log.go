@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Values of the logging flags shared by all commands
+type LogFlags struct {
+	format *string
+	level  *string
+	file   *string
+}
+
+// Defines -log-format, -log-level and -log-file. Must be called before ParseFlags
+func DefineLogFlags() *LogFlags {
+	return &LogFlags{
+		format: flag.String("log-format", "text", "Format of logs: text or json"),
+		level:  flag.String("log-level", "info", "Minimum level of logs: debug, info, warn or error"),
+		file:   flag.String("log-file", "", "Append logs to this file instead of writing them to stderr"),
+	}
+}
+
+// Default logger until flags are parsed. Logs go to stderr so that stdout can carry output
+func DefaultLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, nil))
+}
+
+// Creates the logger configured by the flags. The returned closer closes the log file, if any
+func (l *LogFlags) NewLogger() (*slog.Logger, io.Closer, error) {
+	var w io.WriteCloser = nopWriteCloser{os.Stderr}
+	if *l.file != "" {
+		f, err := os.OpenFile(*l.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = f
+	}
+	logger, err := newLogger(w, *l.format, *l.level)
+	if err != nil {
+		w.Close()
+		return nil, nil, err
+	}
+	return logger, w, nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level: %s", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format: %s", format)
+}
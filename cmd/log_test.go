@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Run("happy path json above the level", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := newLogger(&buf, "json", "warn")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		logger.Info("hidden")
+		logger.Warn("shown", "track", "song1")
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("expected one json entry, got %s", buf.String())
+		}
+		if entry["msg"] != "shown" || entry["track"] != "song1" {
+			t.Fatalf("unexpected entry: %v", entry)
+		}
+	})
+
+	t.Run("happy path text with debug", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := newLogger(&buf, "text", "DEBUG")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		logger.Debug("skipping track")
+		if !strings.Contains(buf.String(), "level=DEBUG msg=\"skipping track\"") {
+			t.Fatalf("expected debug message, got %s", buf.String())
+		}
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		if _, err := newLogger(nil, "xml", "info"); err == nil {
+			t.Fatalf("expected error for format")
+		}
+		if _, err := newLogger(nil, "text", "verbose"); err == nil {
+			t.Fatalf("expected error for level")
+		}
+	})
+}
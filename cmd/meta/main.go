@@ -3,62 +3,220 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/cleoold/soundtrack-downloader/cmd"
 	"github.com/cleoold/soundtrack-downloader/pkg"
 )
 
-type tagFlags map[string]string
+type tagFlags map[string][]string
 
 func (t tagFlags) String() string {
-	return fmt.Sprintf("%+v", map[string]string(t))
+	return fmt.Sprintf("%+v", map[string][]string(t))
 }
 
+// Repeating a key adds another value
 func (t tagFlags) Set(value string) error {
 	res := strings.SplitN(value, "=", 2)
 	if len(res) != 2 {
 		return fmt.Errorf("invalid tag format: %s", value)
 	}
-	t[strings.ToUpper(res[0])] = res[1]
+	key := strings.ToUpper(res[0])
+	t[key] = append(t[key], res[1])
 	return nil
 }
 
-type overrideFlags pkg.TagKeySet
+type cleanupRuleFlags []pkg.TagValueRule
 
-func (o overrideFlags) String() string {
-	return fmt.Sprintf("%+v", pkg.TagKeySet(o))
+func (c *cleanupRuleFlags) String() string {
+	return fmt.Sprintf("%d rules", len(*c))
 }
 
-func (o overrideFlags) Set(value string) error {
-	for part := range strings.SplitSeq(value, ",") {
-		pkg.TagKeySet(o).Add(strings.TrimSpace(part))
+func (c *cleanupRuleFlags) Set(value string) error {
+	rule, err := pkg.ParseTagValueRule(value)
+	if err != nil {
+		return err
 	}
+	*c = append(*c, rule)
 	return nil
 }
 
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	logger := cmd.DefaultLogger()
 	flag.Usage = cmd.PrintUsage
+	logFlags := cmd.DefineLogFlags()
 	folderFlag := flag.String("folder", "", "Folder to fix tags")
 	tags := tagFlags{}
-	flag.Var(&tags, "tag", "Tag to set. Format: -tag key=value. Multiple are supported. Available keys include 'ALBUM', 'DATE', 'ALBUMARTIST', 'ARTIST', 'GENRE' and so on. See https://taglib.org/api/p_propertymapping.html for more. If provided, this option has higher precedence than ones scanned by -read-album-info.")
+	flag.Var(&tags, "tag", "Tag to set. Format: -tag key=value. Multiple are supported, and repeating a key such as -tag ARTIST=a -tag ARTIST=b sets multiple values. Available keys include 'ALBUM', 'DATE', 'ALBUMARTIST', 'ARTIST', 'GENRE' and so on. See https://taglib.org/api/p_propertymapping.html for more. If provided, this option has higher precedence than ones scanned by -read-album-info.")
 	inferNamesFlag := flag.Bool("infer-names", false, "Infer track names from file names. Default: false")
-	overwrites := overrideFlags{}
+	overwrites := cmd.KeySetFlags{}
 	flag.Var(&overwrites, "overwrite", "Overwrite existing tags by their key names (example: -overwrite ALBUM,ARTIST,TRACKNUMBER). Special value '*' means to overwrite all tags. Default: none")
 	readAlbumInfoFlag := flag.Bool("read-album-info", false, "Read album info from info.json. Default: false")
 	noFixFlag := flag.Bool("no-fix", false, "Only print the proposed changes but don't fix tags. Default: false")
-	flag.Parse()
+	exportFlag := flag.String("export", "", "Export tags of all music files into a CSV or JSON file, one row per file, then exit. Use '-' for stdout")
+	importFlag := flag.String("import", "", "Import tags from a CSV or JSON file created by -export and apply them as file-specific tags. Honors -overwrite and -no-fix")
+	tableFormatFlag := flag.String("table-format", "", "Format of the -export and -import file: csv or json. Default to the file extension, or csv")
+	recursiveFlag := flag.Bool("recursive", false, "Also include music files in subfolders for -export and -import, e.g. for a whole library. Default: false")
+	removes := cmd.KeySetFlags{}
+	flag.Var(&removes, "remove", "Remove existing tags by their key names (example: -remove COMMENT,ENCODEDBY). Not subject to -overwrite. Default: none")
+	keepOnly := cmd.KeySetFlags{}
+	flag.Var(&keepOnly, "keep-only", "Remove all existing tags except these key names (example: -keep-only ALBUM,ARTIST,TITLE,TRACKNUMBER). Default: keep all")
+	cleanupRules := cleanupRuleFlags{}
+	flag.Var(&cleanupRules, "clean", "Rule to clean up tag values before writing, applied to both existing and new values. Format: KEY[,KEY...]:ACTION, where ACTION is s/regex/replacement/[i], upper, lower, title, first (keep the first value only) or unique (drop duplicate values). Special key '*' means all tags. Multiple are supported and applied in order. Example: -clean 'COMMENT:s/ripped by.*//i'")
+	joinFormats := cmd.KeySetFlags{}
+	flag.Var(&joinFormats, "join-multi-values", "File formats for which multiple values of a tag are joined with '; ' into a single value, for players that only show the first value (example: -join-multi-values MP3,M4A). Special value '*' means all formats. Default: none, write multiple values")
+	lintFlag := flag.Bool("lint", false, "Check tags of the album for inconsistencies and mismatches with info.json, then exit. Exits with code 1 if issues are found. Default: false")
+	lintFormatFlag := flag.String("lint-format", "text", "Output format of -lint: text or json")
+	if err := cmd.ParseFlags("meta"); err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	logger, logFile, err := logFlags.NewLogger()
+	if err != nil {
+		cmd.DefaultLogger().Error(err.Error())
+		os.Exit(1)
+	}
+	defer logFile.Close()
 	if *folderFlag == "" {
 		flag.Usage()
 		logger.Error("folder is required")
 		return
 	}
+	if *exportFlag != "" && *importFlag != "" {
+		logger.Error("export and import cannot be used together")
+		os.Exit(1)
+	}
+
+	cleanup := pkg.TagCleanup{Remove: pkg.TagKeySet(removes), KeepOnly: pkg.TagKeySet(keepOnly), Rules: cleanupRules}
+
+	if *lintFlag {
+		os.Exit(lint(logger, *folderFlag, *lintFormatFlag))
+	}
+
+	if *exportFlag != "" {
+		if err := exportTags(logger, *folderFlag, *exportFlag, *tableFormatFlag, *recursiveFlag); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *importFlag != "" {
+		dirFileTags, err := importTags(*importFlag, *tableFormatFlag)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		for dir, fileTags := range dirFileTags {
+			if dir != "." && !*recursiveFlag {
+				logger.Warn("skipping files in subfolder without -recursive: " + dir)
+				continue
+			}
+			err := pkg.FixTags(logger, tags, fileTags, pkg.TagKeySet(overwrites), cleanup, pkg.InsStringKeySet(joinFormats), filepath.Join(*folderFlag, dir), *inferNamesFlag, *readAlbumInfoFlag, *noFixFlag)
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}
+		return
+	}
+
+	err = pkg.FixTags(logger, tags, nil, pkg.TagKeySet(overwrites), cleanup, pkg.InsStringKeySet(joinFormats), *folderFlag, *inferNamesFlag, *readAlbumInfoFlag, *noFixFlag)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
+
+func tableFormat(fileName, format string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(fileName), ".")
+		if format == "" {
+			format = "csv"
+		}
+	}
+	switch format = strings.ToLower(format); format {
+	case "csv", "json":
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported table format: %s", format)
+}
+
+func exportTags(logger *slog.Logger, folder, fileName, format string, recursive bool) error {
+	format, err := tableFormat(fileName, format)
+	if err != nil {
+		return err
+	}
+	rows, err := pkg.ReadTagTable(logger, folder, recursive)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if fileName != "-" {
+		f, err := os.Create(fileName)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "json" {
+		return pkg.WriteTagTableJSON(w, rows)
+	}
+	return pkg.WriteTagTableCSV(w, rows)
+}
+
+func importTags(fileName, format string) (map[string]map[string]map[string][]string, error) {
+	format, err := tableFormat(fileName, format)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = os.Stdin
+	if fileName != "-" {
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var rows []pkg.TagRow
+	if format == "json" {
+		rows, err = pkg.ReadTagTableJSON(r)
+	} else {
+		rows, err = pkg.ReadTagTableCSV(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag table: %w", err)
+	}
+	return pkg.TagTableToFileTags(rows)
+}
 
-	err := pkg.FixTags(logger, tags, nil, pkg.TagKeySet(overwrites), *folderFlag, *inferNamesFlag, *readAlbumInfoFlag, *noFixFlag)
+// Returns the exit code: 0 if no issues, 1 if issues are found and 2 if linting failed
+func lint(logger *slog.Logger, folder, format string) int {
+	var write func(io.Writer, []pkg.LintIssue) error
+	switch strings.ToLower(format) {
+	case "text":
+		write = pkg.WriteLintIssuesText
+	case "json":
+		write = pkg.WriteLintIssuesJSON
+	default:
+		logger.Error("unsupported lint format: " + format)
+		return 2
+	}
+	issues, err := pkg.LintAlbum(folder)
 	if err != nil {
 		logger.Error(err.Error())
+		return 2
+	}
+	if err := write(os.Stdout, issues); err != nil {
+		logger.Error(err.Error())
+		return 2
+	}
+	if len(issues) != 0 {
+		return 1
 	}
+	return 0
 }
@@ -24,12 +24,16 @@ func TestTagFlags(t *testing.T) {
 			t.Fatalf("unexpected error: %v", err)
 		}
 
+		if err := s.Set("artist=Other Artist"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
 		expected := tagFlags{
-			"ALBUM":       "My Album",
-			"DATE":        "2021",
-			"ALBUMARTIST": "My Artist ",
-			"ARTIST":      "My Artist",
-			"GENRE":       "myType",
+			"ALBUM":       {"My Album"},
+			"DATE":        {"2021"},
+			"ALBUMARTIST": {"My Artist "},
+			"ARTIST":      {"My Artist", "Other Artist"},
+			"GENRE":       {"myType"},
 		}
 		if !reflect.DeepEqual(s, expected) {
 			t.Fatalf("expected %v, got %v", expected, s)
@@ -44,17 +48,38 @@ func TestTagFlags(t *testing.T) {
 	})
 }
 
-func TestOverrideFlags(t *testing.T) {
-	t.Run("Happy path", func(t *testing.T) {
-		s := overrideFlags{}
-		if err := s.Set("ALBUM,artist,TRACKNUMBER"); err != nil {
-			t.Fatalf("unexpected error: %v", err)
+func TestTableFormat(t *testing.T) {
+	tests := []struct {
+		fileName, format, expected string
+	}{
+		{"tags.csv", "", "csv"},
+		{"tags.JSON", "", "json"},
+		{"-", "", "csv"},
+		{"-", "JSON", "json"},
+		{"tags.txt", "csv", "csv"},
+	}
+	for _, tt := range tests {
+		if res, err := tableFormat(tt.fileName, tt.format); err != nil || res != tt.expected {
+			t.Fatalf("expected %s for %s, got %s (err: %v)", tt.expected, tt.fileName, res, err)
 		}
+	}
+	if _, err := tableFormat("tags.xlsx", ""); err == nil {
+		t.Fatalf("expected error")
+	}
+}
 
-		vacuum := struct{}{}
-		expected := overrideFlags{"ALBUM": vacuum, "ARTIST": vacuum, "TRACKNUMBER": vacuum}
-		if !reflect.DeepEqual(s, expected) {
-			t.Fatalf("expected %v, got %v", expected, s)
-		}
-	})
+func TestCleanupRuleFlags(t *testing.T) {
+	c := cleanupRuleFlags{}
+	if err := c.Set("COMMENT:s/ripped by.*//i"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Set("ARTIST,ALBUMARTIST:first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c) != 2 || c[1].Action != "first" {
+		t.Fatalf("expected 2 rules, got %v", c)
+	}
+	if err := c.Set("COMMENT"); err == nil {
+		t.Fatalf("expected error")
+	}
 }
@@ -4,6 +4,7 @@ go 1.24.0
 
 require (
 	github.com/PuerkitoBio/goquery v1.10.2
+	github.com/klauspost/compress v1.18.0
 	go.senan.xyz/taglib v0.6.1
 )
 
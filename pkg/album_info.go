@@ -1,5 +1,12 @@
 package pkg
 
+const (
+	StatusComplete = "complete"
+	// Some files failed to download
+	StatusIncomplete  = "incomplete"
+	StatusInterrupted = "interrupted"
+)
+
 type AlbumInfo struct {
 	Url              string
 	Name             string
@@ -11,9 +18,16 @@ type AlbumInfo struct {
 	CatalogNumber    []string
 	AlbumType        []string
 	Description      string
+	NumberOfFiles    int    `json:",omitzero"`
+	TotalFilesize    int64  `json:",omitzero"`
+	DateAdded        string `json:",omitzero"`
 
 	Images []ImageInfo
 	Tracks []TrackInfo
+	// Subfolders by format, when several formats are downloaded
+	FormatFolders map[string]string `json:",omitzero"`
+	// Outcome of the last download
+	Status string `json:",omitzero"`
 }
 
 type ImageInfo struct {
@@ -27,4 +41,8 @@ type TrackInfo struct {
 	TrackNumber string
 	PageUrl     string
 	SongUrl     map[string]string
+	// As displayed in the song list, e.g. 4:27
+	Duration string `json:",omitzero"`
+	// Approximate sizes in bytes by upper case format, as displayed in the song list
+	Sizes map[string]int64 `json:",omitzero"`
 }
@@ -0,0 +1,317 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var ArchiveFormats = []string{"zip", "tar", "tar.zst"}
+
+// Writes the files of an album into an archive named after the album folder, in place of the
+// os functions given to fetchAlbum. Entries keep the album folder in their names. The archive is
+// written as .part and only renamed once closed with every entry in it complete. Downloads are
+// streamed into tar entries, which needs their size up front, so files downloaded without a
+// Content-Length fail. Zip entries are downloaded to a file next to the archive first, and only
+// added once complete
+type albumArchive struct {
+	osCreate func(string) (io.WriteCloser, error)
+	osOpen   func(string) (io.ReadCloser, error)
+	osRename func(string, string) error
+	osRemove func(string) error
+	format   string
+	now      func() time.Time
+
+	file io.WriteCloser
+	path string
+	zstd *zstd.Encoder
+	zip  *zip.Writer
+	tar  *tar.Writer
+	base string
+	// Content-Length of the last file downloaded, or -1 if unknown
+	downloadSize int64
+	// Tar entry being downloaded, which is incomplete unless renamed
+	tarEntry   string
+	incomplete []string
+}
+
+func newAlbumArchive(
+	osCreate func(string) (io.WriteCloser, error),
+	osOpen func(string) (io.ReadCloser, error),
+	osRename func(string, string) error,
+	osRemove func(string) error,
+	format string,
+) (*albumArchive, error) {
+	switch format {
+	case "zip", "tar", "tar.zst":
+	default:
+		return nil, fmt.Errorf("invalid archive format: %s", format)
+	}
+	return &albumArchive{osCreate: osCreate, osOpen: osOpen, osRename: osRename, osRemove: osRemove, format: format, now: time.Now, downloadSize: -1}, nil
+}
+
+// Records the size of each file downloaded through the client, which is the size of the
+// .part file created right after
+type archiveSizeClient struct {
+	client  HttpDoClient
+	archive *albumArchive
+}
+
+func (c *archiveSizeClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err == nil && req.Method == http.MethodGet {
+		c.archive.downloadSize = -1
+		if resp.StatusCode == http.StatusOK && resp.ContentLength > 0 {
+			c.archive.downloadSize = resp.ContentLength
+		}
+	}
+	return resp, err
+}
+
+// The album folder is created first, which opens the archive. Subfolders are implied by entries
+func (a *albumArchive) MkdirAll(name string, perm os.FileMode) error {
+	if a.file != nil {
+		return nil
+	}
+	a.path, a.base = name+"."+a.format, path.Dir(name)
+	file, err := a.osCreate(a.path + ".part")
+	if err != nil {
+		return err
+	}
+	a.file = file
+	switch a.format {
+	case "zip":
+		a.zip = zip.NewWriter(file)
+	case "tar":
+		a.tar = tar.NewWriter(file)
+	case "tar.zst":
+		if a.zstd, err = zstd.NewWriter(file); err != nil {
+			return err
+		}
+		a.tar = tar.NewWriter(a.zstd)
+	}
+	return nil
+}
+
+func (a *albumArchive) entryName(name string) string {
+	if a.base != "." {
+		return strings.TrimPrefix(name, a.base+"/")
+	}
+	return name
+}
+
+func (a *albumArchive) createZipEntry(name string) (io.Writer, error) {
+	// Audio and images hardly compress
+	method := zip.Store
+	if ext := path.Ext(name); ext == ".json" || ext == ".url" {
+		method = zip.Deflate
+	}
+	return a.zip.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: a.now()})
+}
+
+type zipEntry struct {
+	io.Writer
+}
+
+func (e zipEntry) Close() error {
+	return nil
+}
+
+// Tar entry of a known size, padded with zeros if fewer bytes are written
+type tarEntry struct {
+	tar       *tar.Writer
+	remaining int64
+}
+
+func (e *tarEntry) Write(p []byte) (int, error) {
+	n, err := e.tar.Write(p)
+	e.remaining -= int64(n)
+	return n, err
+}
+
+func (e *tarEntry) Close() error {
+	if e.remaining <= 0 {
+		return nil
+	}
+	missing := e.remaining
+	if _, err := io.CopyN(e, zeroReader{}, missing); err != nil {
+		return err
+	}
+	return fmt.Errorf("entry is %d bytes short", missing)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// Tar entry of info.json or the shortcut, which are small and written once closed
+type bufferedTarEntry struct {
+	bytes.Buffer
+	close func([]byte) error
+}
+
+func (e *bufferedTarEntry) Close() error {
+	return e.close(e.Bytes())
+}
+
+// Opens an entry. A .part file is a download, which is complete once renamed
+func (a *albumArchive) Create(name string) (io.WriteCloser, error) {
+	if a.file == nil {
+		return nil, fmt.Errorf("archive is not open")
+	}
+	size := a.downloadSize
+	a.downloadSize = -1
+	if a.tarEntry != "" {
+		a.incomplete, a.tarEntry = append(a.incomplete, a.tarEntry), ""
+	}
+	name, isDownload := strings.CutSuffix(name, ".part")
+	name = a.entryName(name)
+	switch {
+	case a.zip != nil && isDownload:
+		return a.osCreate(a.path + ".entry")
+	case a.zip != nil:
+		w, err := a.createZipEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		return zipEntry{w}, nil
+	case isDownload:
+		if size < 0 {
+			return nil, fmt.Errorf("size of %s is unknown, which a tar archive needs up front; use zip instead", name)
+		}
+		if err := a.tar.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: a.now()}); err != nil {
+			return nil, err
+		}
+		a.tarEntry = name
+		return &tarEntry{tar: a.tar, remaining: size}, nil
+	}
+	header := &tar.Header{Name: name, Mode: 0o644, ModTime: a.now()}
+	return &bufferedTarEntry{close: func(data []byte) error {
+		header.Size = int64(len(data))
+		if err := a.tar.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := a.tar.Write(data)
+		return err
+	}}, nil
+}
+
+func (a *albumArchive) Append(name string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("appending to %s in an archive is not supported", name)
+}
+
+// Completes a download, adding it to a zip archive
+func (a *albumArchive) Rename(oldName, newName string) error {
+	if oldName != newName+".part" {
+		return fmt.Errorf("renaming %s in an archive is not supported", oldName)
+	}
+	if a.tar != nil {
+		a.tarEntry = ""
+		return nil
+	}
+	src, err := a.osOpen(a.path + ".entry")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	w, err := a.createZipEntry(a.entryName(newName))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+func (a *albumArchive) Stat(name string) (os.FileInfo, error) {
+	return nil, os.ErrNotExist
+}
+
+// Closes the archive, which is left as .part if an entry is incomplete
+func (a *albumArchive) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	if a.tarEntry != "" {
+		a.incomplete, a.tarEntry = append(a.incomplete, a.tarEntry), ""
+	}
+	var err error
+	if a.zip != nil {
+		err = a.zip.Close()
+		if removeErr := a.osRemove(a.path + ".entry"); !errors.Is(removeErr, os.ErrNotExist) && err == nil {
+			err = removeErr
+		}
+	} else {
+		err = a.tar.Close()
+	}
+	if a.zstd != nil {
+		if zstdErr := a.zstd.Close(); err == nil {
+			err = zstdErr
+		}
+	}
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && len(a.incomplete) > 0 {
+		err = fmt.Errorf("archive left as %s.part, as these entries are incomplete: %s", a.path, strings.Join(a.incomplete, ", "))
+	}
+	if err != nil {
+		return err
+	}
+	return a.osRename(a.path+".part", a.path)
+}
+
+// Downloads an album into <album folder>.<format> in workPath instead of a folder. Existing files
+// are not checked, and an existing archive is replaced
+func FetchAlbumToArchive(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	workPath,
+	albumUrl,
+	format string,
+	noDownloadImage,
+	noDownloadTrack,
+	noCreateInfo,
+	noCreateShortcut bool,
+	trackNumberSet TrackNumberSet,
+	trackFormatRanking TrackFormatRanking,
+	formats []string,
+	maxSize int64,
+	downgrade bool,
+) (*DownloadPlan, error) {
+	osOpen := func(name string) (io.ReadCloser, error) {
+		return os.Open(name) // covariance
+	}
+	archive, err := newAlbumArchive(osCreateFile, osOpen, os.Rename, os.Remove, format)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := fetchAlbum(ctx, &archiveSizeClient{httpClient, archive}, logger, archive.MkdirAll, archive.Create, archive.Append, archive.Rename, archive.Stat, DiskFreeSpace, workPath, albumUrl, noDownloadImage, noDownloadTrack, noCreateInfo, noCreateShortcut, true, trackNumberSet, trackFormatRanking, formats, maxSize, downgrade)
+	if plan != nil {
+		plan.Archive = plan.Folder + "." + format
+	}
+	if closeErr := archive.Close(); closeErr != nil {
+		closeErr = fmt.Errorf("failed to write archive: %w", closeErr)
+		if err != nil {
+			logger.Error(closeErr.Error())
+		} else {
+			err = closeErr
+		}
+	}
+	return plan, err
+}
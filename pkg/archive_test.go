@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func readZipEntries(t *testing.T, content string) map[string]string {
+	t.Helper()
+	r, err := zip.NewReader(strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		entries[f.Name] = string(data)
+	}
+	return entries
+}
+
+func readTarEntries(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	tr := tar.NewReader(r)
+	entries := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		entries[header.Name] = string(data)
+	}
+}
+
+// Reports the length of the body as the Content-Length of successful responses. Broken bodies
+// of files end early as when the connection is lost
+type sizedClient struct {
+	client HttpDoClient
+	broken bool
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func (c sizedClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	if c.broken && req.URL.Host == "download.com" {
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), brokenReader{}))
+		resp.ContentLength += 2
+	}
+	return resp, nil
+}
+
+func TestFetchAlbumToArchive(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	mkDiskFreeSpace := func(string) (int64, error) { return 1 << 40, nil }
+	client := stubClient{
+		"https://example.com/":                  {"GET": {http.StatusOK, home1}},
+		"https://example.com/01.%2520song1.mp3": {"GET": {http.StatusOK, song1}},
+		"https://example.com/01.%2520song2.mp3": {"GET": {http.StatusOK, strings.ReplaceAll(strings.ReplaceAll(song1, "song1", "song2"), "01", "02")}},
+		"https://download.com/Cover.jpg":        {"GET": {http.StatusOK, "content of cover"}},
+		"https://download.com/01.%20song1.flac": {"GET": {http.StatusOK, "content of song1"}},
+		"https://download.com/02.%20song2.flac": {"GET": {http.StatusInternalServerError, "error"}},
+	}
+	fetch := func(client HttpDoClient, format string) (FSRecorder, error, error) {
+		mkFS := FSRecorder{}
+		archive, err := newAlbumArchive(mkFS.Create, mkFS.Open, mkFS.Rename, mkFS.Remove, format)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = fetchAlbum(context.Background(), &archiveSizeClient{client, archive}, logger, archive.MkdirAll, archive.Create, archive.Append, archive.Rename, archive.Stat, mkDiskFreeSpace, "music", "https://example.com/", false, false, true, false, true, DownloadAllTracks, TrackFormatRanking{"FLAC"}, nil, 0, false)
+		return mkFS, err, archive.Close()
+	}
+	// The failed download of song2 is left out
+	expected := map[string]string{
+		"My Album 1/Cover.jpg":      "content of cover",
+		"My Album 1/01. song1.flac": "content of song1",
+		"My Album 1/page.url":       "[{000214A0-0000-0000-C000-000000000046}]\r\nProp3=19,11\r\n[InternetShortcut]\r\nIDList=\r\nURL=https://example.com/\r\n",
+	}
+	expectDownloadErrors := func(t *testing.T, err error, count int) {
+		t.Helper()
+		var downloadErrs DownloadErrors
+		if !errors.As(err, &downloadErrs) || len(downloadErrs) != count {
+			t.Fatalf("expected %d download errors, got %v", count, err)
+		}
+	}
+	expectOnly := func(t *testing.T, mkFS FSRecorder, name string) {
+		t.Helper()
+		if _, ok := mkFS[name]; !ok || len(mkFS) != 1 {
+			t.Fatalf("expected only %v, got %v", name, reflect.ValueOf(mkFS).MapKeys())
+		}
+	}
+
+	t.Run("happy path zip", func(t *testing.T) {
+		mkFS, err, closeErr := fetch(client, "zip")
+		expectDownloadErrors(t, err, 1)
+		if closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+		expectOnly(t, mkFS, "music/My Album 1.zip")
+		if entries := readZipEntries(t, mkFS["music/My Album 1.zip"]); !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected %v, got %v", expected, entries)
+		}
+	})
+
+	t.Run("happy path tar", func(t *testing.T) {
+		mkFS, err, closeErr := fetch(sizedClient{client, false}, "tar")
+		expectDownloadErrors(t, err, 1)
+		if closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+		expectOnly(t, mkFS, "music/My Album 1.tar")
+		if entries := readTarEntries(t, strings.NewReader(mkFS["music/My Album 1.tar"])); !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected %v, got %v", expected, entries)
+		}
+	})
+
+	t.Run("happy path tar.zst", func(t *testing.T) {
+		mkFS, _, _ := fetch(sizedClient{client, false}, "tar.zst")
+		decoder, err := zstd.NewReader(bytes.NewReader([]byte(mkFS["music/My Album 1.tar.zst"])))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer decoder.Close()
+		if entries := readTarEntries(t, decoder); !reflect.DeepEqual(entries, expected) {
+			t.Fatalf("expected %v, got %v", expected, entries)
+		}
+	})
+
+	t.Run("tar fails downloads of unknown size", func(t *testing.T) {
+		mkFS, err, closeErr := fetch(client, "tar")
+		expectDownloadErrors(t, err, 3)
+		if closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+		entries := readTarEntries(t, strings.NewReader(mkFS["music/My Album 1.tar"]))
+		if _, ok := entries["My Album 1/page.url"]; !ok || len(entries) != 1 {
+			t.Fatalf("expected only the shortcut, got %v", entries)
+		}
+	})
+
+	t.Run("broken download is left out of zip", func(t *testing.T) {
+		mkFS, err, closeErr := fetch(sizedClient{client, true}, "zip")
+		expectDownloadErrors(t, err, 3)
+		if closeErr != nil {
+			t.Fatalf("unexpected error: %v", closeErr)
+		}
+		expectOnly(t, mkFS, "music/My Album 1.zip")
+		entries := readZipEntries(t, mkFS["music/My Album 1.zip"])
+		if _, ok := entries["My Album 1/page.url"]; !ok || len(entries) != 1 {
+			t.Fatalf("expected only the shortcut, got %v", entries)
+		}
+	})
+
+	t.Run("broken download leaves tar as part", func(t *testing.T) {
+		mkFS, err, closeErr := fetch(sizedClient{client, true}, "tar")
+		expectDownloadErrors(t, err, 3)
+		if closeErr == nil {
+			t.Fatalf("expected error, got nil")
+		}
+		expectOnly(t, mkFS, "music/My Album 1.tar.part")
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		if _, err := newAlbumArchive(FSRecorder{}.Create, FSRecorder{}.Open, FSRecorder{}.Rename, FSRecorder{}.Remove, "rar"); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	URL "net/url"
+	"path"
+)
+
+var ErrOverBudget = errors.New("download exceeds size budget")
+
+// Assumed size of an item whose size is unknown, unless larger items are known
+const unknownItemSize = 50 << 20
+
+// Whether the items of unknown size could take the plan over the limit, assuming each is at most
+// as large as the largest known item or unknownItemSize
+func unknownSizesAtRisk(plan *DownloadPlan, limit int64) bool {
+	unknown, largest := int64(0), int64(unknownItemSize)
+	for _, item := range plan.Items {
+		if item.SkipReason != "" {
+			continue
+		}
+		if item.Size == 0 && item.Url != "" {
+			unknown++
+		}
+		largest = max(largest, item.Size)
+	}
+	return limit >= 0 && unknown > 0 && limit-plan.TotalSize < unknown*largest
+}
+
+// Fills in unknown sizes of items to download with HEAD requests. Failures are ignored
+func estimatePlanSizes(ctx context.Context, httpClient HttpDoClient, plan *DownloadPlan) {
+	for i := range plan.Items {
+		item := &plan.Items[i]
+		if item.SkipReason != "" || item.Size > 0 || item.Url == "" {
+			continue
+		}
+		unescaped, _ := URL.QueryUnescape(item.Url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, unescaped, nil)
+		if err != nil {
+			continue
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && resp.ContentLength > 0 {
+			item.Size = resp.ContentLength
+		}
+	}
+	plan.updateTotalSize()
+}
+
+// Makes sure the plan fits in the free space of workPath and in maxSize if positive. If it does
+// not, tracks are switched to smaller formats if downgrade is set, otherwise ErrOverBudget is
+// returned. Unknown sizes such as of images are only requested when they could exceed the limit
+func enforceBudget(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	diskFreeSpace func(string) (int64, error),
+	plan *DownloadPlan,
+	workPath string,
+	maxSize int64,
+	downgrade bool,
+) error {
+	limit := int64(-1)
+	if maxSize > 0 {
+		limit = maxSize
+	}
+	if free, err := diskFreeSpace(workPath); err != nil {
+		logger.Warn("failed to get free disk space: " + err.Error())
+	} else if limit < 0 || free < limit {
+		limit = free
+	}
+	plan.updateTotalSize()
+	if unknownSizesAtRisk(plan, limit) {
+		estimatePlanSizes(ctx, httpClient, plan)
+	}
+	if limit < 0 || plan.TotalSize <= limit {
+		return nil
+	}
+	overErr := fmt.Errorf("%w: %s needed, %s allowed", ErrOverBudget, FormatByteSize(plan.TotalSize), FormatByteSize(limit))
+	if !downgrade {
+		return overErr
+	}
+	if len(plan.Album.FormatFolders) > 0 {
+		return fmt.Errorf("%w, and formats cannot be downgraded when downloading several", overErr)
+	}
+
+	tracks := map[string]*TrackInfo{}
+	for i := range plan.Album.Tracks {
+		tracks[plan.Album.Tracks[i].PageUrl] = &plan.Album.Tracks[i]
+	}
+	// Repeatedly switch the largest track to its next smaller format
+	for plan.TotalSize > limit {
+		var chosen *PlanItem
+		var chosenFormat string
+		for i := range plan.Items {
+			item := &plan.Items[i]
+			t := tracks[item.PageUrl]
+			if item.Kind != PlanTrack || item.SkipReason != "" || t == nil || (chosen != nil && item.Size <= chosen.Size) {
+				continue
+			}
+			// Largest format that is smaller than the current one
+			format := ""
+			for f, size := range t.Sizes {
+				if _, ok := t.SongUrl[f]; ok && size > 0 && size < item.Size && (format == "" || size > t.Sizes[format]) {
+					format = f
+				}
+			}
+			if format != "" {
+				chosen, chosenFormat = item, format
+			}
+		}
+		if chosen == nil {
+			return overErr
+		}
+		t := tracks[chosen.PageUrl]
+		logger.Info("downgrading format to fit the budget", "track", chosen.Name, "from", chosen.Format, "to", chosenFormat)
+		chosen.Url = t.SongUrl[chosenFormat]
+		chosen.Path = downloadPath(path.Dir(chosen.Path), chosen.Url)
+		chosen.Format, chosen.Size, chosen.Probe = chosenFormat, t.Sizes[chosenFormat], nil
+		plan.updateTotalSize()
+	}
+	logger.Info("downgraded formats to fit the budget", "size", FormatByteSize(plan.TotalSize))
+	return nil
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEnforceBudget(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	client := &recordingClient{respond: func(req *http.Request) *http.Response {
+		return &http.Response{StatusCode: http.StatusOK, ContentLength: 1 << 20, Body: io.NopCloser(strings.NewReader(""))}
+	}}
+	newPlan := func() *DownloadPlan {
+		sizes := map[string]int64{"FLAC": 30 << 20, "MP3": 8 << 20, "OGG": 6 << 20}
+		album := &AlbumInfo{Tracks: []TrackInfo{
+			{Name: "song1", PageUrl: "p1", SongUrl: map[string]string{"FLAC": "https://a.com/1.flac", "MP3": "https://a.com/1.mp3", "OGG": "https://a.com/1.ogg"}, Sizes: sizes},
+			{Name: "song2", PageUrl: "p2", SongUrl: map[string]string{"FLAC": "https://a.com/2.flac", "MP3": "https://a.com/2.mp3"}, Sizes: sizes},
+		}}
+		return &DownloadPlan{Album: album, Folder: "Album", Items: []PlanItem{
+			{Kind: PlanImage, Url: "https://a.com/cover.jpg", Path: "Album/cover.jpg"},
+			{Kind: PlanTrack, Name: "song1", PageUrl: "p1", Url: "https://a.com/1.flac", Path: "Album/1.flac", Format: "FLAC", Size: 30 << 20},
+			{Kind: PlanTrack, Name: "song2", PageUrl: "p2", Url: "https://a.com/2.flac", Path: "Album/2.flac", Format: "FLAC", Size: 30 << 20},
+		}}
+	}
+	freeSpace := func(free int64) func(string) (int64, error) {
+		return func(string) (int64, error) { return free, nil }
+	}
+
+	t.Run("happy path fits without changes or requests", func(t *testing.T) {
+		client := &recordingClient{respond: client.respond}
+		plan := newPlan()
+		if err := enforceBudget(context.Background(), client, logger, freeSpace(1<<30), plan, ".", 0, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(client.requests) != 0 || plan.TotalSize != 60<<20 {
+			t.Fatalf("expected no requests and known sizes, got %d and %d", len(client.requests), plan.TotalSize)
+		}
+	})
+
+	t.Run("happy path estimates image size close to the limit", func(t *testing.T) {
+		plan := newPlan()
+		if err := enforceBudget(context.Background(), client, logger, freeSpace(1<<30), plan, ".", 100<<20, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.Items[0].Size != 1<<20 || plan.TotalSize != 61<<20 {
+			t.Fatalf("expected estimated sizes, got %d and %d", plan.Items[0].Size, plan.TotalSize)
+		}
+	})
+
+	t.Run("aborts over max size", func(t *testing.T) {
+		if err := enforceBudget(context.Background(), client, logger, freeSpace(1<<30), newPlan(), ".", 40<<20, false); !errors.Is(err, ErrOverBudget) {
+			t.Fatalf("expected %v, got %v", ErrOverBudget, err)
+		}
+	})
+
+	t.Run("happy path downgrades the largest tracks until it fits", func(t *testing.T) {
+		plan := newPlan()
+		if err := enforceBudget(context.Background(), client, logger, freeSpace(1<<30), plan, ".", 40<<20, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.Items[1].Format != "MP3" || plan.Items[1].Path != "Album/1.mp3" || plan.Items[2].Format != "FLAC" || plan.TotalSize != 39<<20 {
+			t.Fatalf("expected song1 to be downgraded to MP3, got %v", plan.Items)
+		}
+	})
+
+	t.Run("fails to downgrade below free space", func(t *testing.T) {
+		if err := enforceBudget(context.Background(), client, logger, freeSpace(10<<20), newPlan(), ".", 0, true); !errors.Is(err, ErrOverBudget) {
+			t.Fatalf("expected %v, got %v", ErrOverBudget, err)
+		}
+	})
+}
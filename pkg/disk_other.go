@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package pkg
+
+import "errors"
+
+func DiskFreeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not supported on this platform")
+}
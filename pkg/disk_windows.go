@@ -0,0 +1,24 @@
+//go:build windows
+
+package pkg
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// Bytes available to the current user on the volume containing path
+func DiskFreeSpace(path string) (int64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	ret, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if ret == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
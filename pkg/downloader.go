@@ -3,6 +3,7 @@ package pkg
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,7 +11,9 @@ import (
 	URL "net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
@@ -52,11 +55,55 @@ func getUrl(ctx context.Context, client HttpDoClient, url string) (io.ReadCloser
 	}
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("unexpected response: %d", resp.StatusCode)
+		return nil, &HttpStatusError{resp.StatusCode}
 	}
 	return resp.Body, nil
 }
 
+// Requests a file to download from offset with a Range header if offset is positive. resumed is
+// false if the server sent the whole content instead
+func getUrlFrom(ctx context.Context, client HttpDoClient, url string, offset int64) (body io.ReadCloser, resumed bool, err error) {
+	ctx = withFileDownload(ctx)
+	if offset <= 0 {
+		body, err := getUrl(ctx, client, url)
+		return body, false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, true, nil
+	case http.StatusOK:
+		return resp.Body, false, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is stale, so start over
+		resp.Body.Close()
+		return getUrlFrom(ctx, client, url, 0)
+	}
+	resp.Body.Close()
+	return nil, false, &HttpStatusError{resp.StatusCode}
+}
+
+// Matched by HttpStatusError of 404 and 410, which means the site moved or removed the page
+var ErrNotFound = errors.New("not found")
+
+type HttpStatusError struct{ StatusCode int }
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("unexpected response: %d", e.StatusCode)
+}
+
+func (e *HttpStatusError) Is(target error) bool {
+	return target == ErrNotFound && (e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone)
+}
+
 var (
 	platformRegex  = regexp.MustCompile(`(?m)Platforms:\s*(.+?)\s*$`)
 	yearRegex      = regexp.MustCompile(`(?m)Year:\s*(.+?)\s*$`)
@@ -64,6 +111,11 @@ var (
 	publisherRegex = regexp.MustCompile(`(?m)Published by:\s*(.+?)\s*$`)
 	catalogRegex   = regexp.MustCompile(`(?m)Catalog Number:\s*(.+?)\s*$`)
 	albumTypeRegex = regexp.MustCompile(`(?m)Album type:\s*(.+?)\s*$`)
+	fileCountRegex = regexp.MustCompile(`(?m)Number of Files:\s*(\d+)\s*$`)
+	filesizeRegex  = regexp.MustCompile(`(?m)Total Filesize:\s*(.+?)\s*$`)
+	dateAddedRegex = regexp.MustCompile(`(?m)Date Added:\s*(.+?)\s*$`)
+	durationRegex  = regexp.MustCompile(`^\d+(:\d{2}){1,2}$`)
+	sizeCellRegex  = regexp.MustCompile(`^\d[\d.,]*\s*[KMGT]?B$`)
 )
 
 func FetchAlbumInfo(ctx context.Context, httpClient HttpDoClient, albumUrl string) (*AlbumInfo, error) {
@@ -127,6 +179,15 @@ func FetchAlbumInfo(ctx context.Context, httpClient HttpDoClient, albumUrl strin
 		if match := albumTypeRegex.FindStringSubmatch(text); len(match) > 1 && match[1] != "N/A" {
 			result.AlbumType = strings.Split(match[1], splitter)
 		}
+		if match := fileCountRegex.FindStringSubmatch(text); len(match) > 1 {
+			result.NumberOfFiles, _ = strconv.Atoi(match[1])
+		}
+		if match := filesizeRegex.FindStringSubmatch(text); len(match) > 1 {
+			result.TotalFilesize, _ = ParseByteSize(strings.ReplaceAll(match[1], ",", ""))
+		}
+		if match := dateAddedRegex.FindStringSubmatch(text); len(match) > 1 && match[1] != "N/A" {
+			result.DateAdded = match[1]
+		}
 	})
 
 	// Get album description below the track list
@@ -159,18 +220,39 @@ func FetchAlbumInfo(ctx context.Context, httpClient HttpDoClient, albumUrl strin
 	CDIndex := doc.Find("#pageContent #songlist_header th:contains('CD')").Index()
 	TrackIndex := doc.Find("#pageContent #songlist_header th:contains('#')").Index()
 	NameIndex := doc.Find("#pageContent #songlist_header th:contains('Name')").Index()
+	// Format columns follow the name. Their cells are not aligned with the header, since the
+	// duration column has no header, so they are matched by content in order
+	sizeFormats := []string{}
+	doc.Find("#pageContent #songlist_header th").Each(func(i int, s *goquery.Selection) {
+		if text := strings.TrimSpace(strings.ReplaceAll(s.Text(), "\u00a0", " ")); i > NameIndex && text != "" {
+			sizeFormats = append(sizeFormats, strings.ToUpper(text))
+		}
+	})
 
 	// Get links to tracks
 	doc.Find("#pageContent #songlist tr:not(#songlist_header):not(#songlist_footer)").Each(func(i int, s *goquery.Selection) {
 		trackInfo := TrackInfo{SongUrl: map[string]string{}}
+		sizeIndex := 0
 		s.Find("td").Each(func(j int, s *goquery.Selection) {
-			switch j {
-			case CDIndex:
-				trackInfo.DiscNumber = strings.Trim(strings.TrimSpace(s.Text()), ".")
-			case TrackIndex:
-				trackInfo.TrackNumber = strings.Trim(strings.TrimSpace(s.Text()), ".")
-			case NameIndex:
-				trackInfo.Name = strings.TrimSpace(s.Text())
+			text := strings.TrimSpace(s.Text())
+			switch {
+			case j == CDIndex:
+				trackInfo.DiscNumber = strings.Trim(text, ".")
+			case j == TrackIndex:
+				trackInfo.TrackNumber = strings.Trim(text, ".")
+			case j == NameIndex:
+				trackInfo.Name = text
+			case j < NameIndex:
+			case trackInfo.Duration == "" && durationRegex.MatchString(text):
+				trackInfo.Duration = text
+			case sizeIndex < len(sizeFormats) && sizeCellRegex.MatchString(text):
+				if size, err := ParseByteSize(strings.ReplaceAll(text, ",", "")); err == nil {
+					if trackInfo.Sizes == nil {
+						trackInfo.Sizes = map[string]int64{}
+					}
+					trackInfo.Sizes[sizeFormats[sizeIndex]] = size
+				}
+				sizeIndex++
 			}
 		})
 		s.Find("td a").Each(func(j int, s *goquery.Selection) {
@@ -186,10 +268,19 @@ func FetchAlbumInfo(ctx context.Context, httpClient HttpDoClient, albumUrl strin
 	return &result, nil
 }
 
-var downloadTextRegex = regexp.MustCompile(`Click here to download as (.+?)$`)
+var (
+	downloadTextRegex = regexp.MustCompile(`Click here to download as (.+?)$`)
+	downloadSizeRegex = regexp.MustCompile(`\((\d[\d.,]*\s*[KMGT]?i?B)\)`)
+)
+
+type TrackDownload struct {
+	Url string
+	// As displayed on the track page, so only approximate. Zero if unknown
+	Size int64
+}
 
 // upper case keys
-func FetchTrackDownloadUrl(ctx context.Context, httpClient HttpDoClient, pageUrl string) (map[string]string, error) {
+func FetchTrackDownloads(ctx context.Context, httpClient HttpDoClient, pageUrl string) (map[string]TrackDownload, error) {
 	body, err := getUrl(ctx, httpClient, pageUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch track page: %w", err)
@@ -200,13 +291,17 @@ func FetchTrackDownloadUrl(ctx context.Context, httpClient HttpDoClient, pageUrl
 		return nil, fmt.Errorf("failed to parse html file for track page: %w", err)
 	}
 
-	result := map[string]string{}
+	result := map[string]TrackDownload{}
 	doc.Find("#pageContent a span:contains('Click here to download as')").Each(func(i int, s *goquery.Selection) {
 		downloadUrl, ok := s.Parent().Attr("href")
 		if ok {
 			downloadUrl, _ = joinUrl(pageUrl, downloadUrl)
 			if match := downloadTextRegex.FindStringSubmatch(s.Text()); len(match) > 1 {
-				result[strings.ToUpper(match[1])] = downloadUrl
+				download := TrackDownload{Url: downloadUrl}
+				if sizeMatch := downloadSizeRegex.FindStringSubmatch(s.Closest("p").Text()); len(sizeMatch) > 1 {
+					download.Size, _ = ParseByteSize(strings.ReplaceAll(sizeMatch[1], ",", ""))
+				}
+				result[strings.ToUpper(match[1])] = download
 			}
 		}
 	})
@@ -217,13 +312,30 @@ func FetchTrackDownloadUrl(ctx context.Context, httpClient HttpDoClient, pageUrl
 	return result, nil
 }
 
+// upper case keys
+func FetchTrackDownloadUrl(ctx context.Context, httpClient HttpDoClient, pageUrl string) (map[string]string, error) {
+	downloads, err := FetchTrackDownloads(ctx, httpClient, pageUrl)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(downloads))
+	for format, download := range downloads {
+		result[format] = download.Url
+	}
+	return result, nil
+}
+
+// Returns the executed plan, which records the outcome of each item, also along with DownloadErrors
 func fetchAlbum(
 	ctx context.Context,
 	httpClient HttpDoClient,
 	logger *slog.Logger,
 	osMkdirAll func(string, os.FileMode) error,
 	osCreate func(string) (io.WriteCloser, error),
+	osAppend func(string) (io.WriteCloser, error),
+	osRename func(string, string) error,
 	osStat func(string) (os.FileInfo, error),
+	diskFreeSpace func(string) (int64, error),
 	workPath,
 	albumUrl string,
 	noDownloadImage,
@@ -233,98 +345,201 @@ func fetchAlbum(
 	overwrite bool,
 	trackNumberSet TrackNumberSet,
 	trackFormatRanking TrackFormatRanking,
-) (*AlbumInfo, string, error) {
-	logger.Info("fetching from " + albumUrl)
-	albumInfo, err := FetchAlbumInfo(ctx, httpClient, albumUrl)
+	formats []string,
+	maxSize int64,
+	downgrade bool,
+) (*DownloadPlan, error) {
+	plan, err := planAlbum(ctx, httpClient, logger, osStat, workPath, albumUrl, noDownloadImage, noDownloadTrack, overwrite, trackNumberSet, trackFormatRanking, formats)
 	if err != nil {
-		return nil, "", err
-	}
-	folderName := path.Join(workPath, sanitizeFilename(albumInfo.Name))
-
-	logger.Info(
-		"fetched info",
-		"name", albumInfo.Name,
-		"year", albumInfo.Year,
-		"developer", albumInfo.Developer,
-		"publisher", albumInfo.Publisher,
-		"catalogNumber", albumInfo.CatalogNumber,
-		"albumType", albumInfo.AlbumType,
-		"images", len(albumInfo.Images),
-		"tracks", len(albumInfo.Tracks),
-		"folder", folderName,
-	)
-
-	err = osMkdirAll(folderName, os.ModePerm)
+		return nil, err
+	}
+	if err := enforceBudget(ctx, httpClient, logger, diskFreeSpace, plan, workPath, maxSize, downgrade); err != nil {
+		return nil, err
+	}
+	err = executePlan(ctx, httpClient, logger, osMkdirAll, osCreate, osAppend, osRename, osStat, plan, trackFormatRanking, noCreateInfo, noCreateShortcut, overwrite)
+	return plan, err
+}
+
+// Downloads items of the plan that are not skipped. Tracks without a resolved URL, and files whose
+// URL returns 404 or 410, are re-scraped from their pages. Files that still fail are returned as
+// DownloadErrors after the rest is done. Tracks whose path is only known once re-scraped are
+// checked for existing files then, unless overwriting
+func executePlan(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	osMkdirAll func(string, os.FileMode) error,
+	osCreate func(string) (io.WriteCloser, error),
+	osAppend func(string) (io.WriteCloser, error),
+	osRename func(string, string) error,
+	osStat func(string) (os.FileInfo, error),
+	plan *DownloadPlan,
+	trackFormatRanking TrackFormatRanking,
+	noCreateInfo,
+	noCreateShortcut,
+	overwrite bool,
+) error {
+	albumInfo, folderName := plan.Album, plan.Folder
+	err := osMkdirAll(folderName, os.ModePerm)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create directory: %w", err)
+		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	download := func(u, kind string) {
-		logger.Info("downloading from " + u)
-		unescaped, _ := URL.QueryUnescape(u)
-		fileName := path.Join(folderName, sanitizeFilename(path.Base(unescaped)))
-		if !overwrite {
-			if _, err := osStat(fileName); err == nil {
-				logger.Info("skipped " + fileName)
-				return
+	var rescrapedAlbum *AlbumInfo
+	rescrape := func(item *PlanItem) error {
+		switch item.Kind {
+		case PlanTrack:
+			if item.PageUrl == "" {
+				return fmt.Errorf("no track page to re-scrape")
+			}
+			downloads, err := FetchTrackDownloads(ctx, httpClient, item.PageUrl)
+			if err != nil {
+				return err
+			}
+			format, ok := item.Format, false
+			if _, ok = downloads[format]; !ok && albumInfo.FormatFolders == nil {
+				format, item.Probe, ok = chooseTrackFormat(trackFormatRanking, downloads, newAudioProber(ctx, httpClient))
+			}
+			if !ok {
+				return fmt.Errorf("%w for %s", ErrNoPreferredFormat, item.Name)
+			}
+			item.Url, item.Format, item.Size = downloads[format].Url, format, downloads[format].Size
+			if item.Path == "" {
+				item.Path = downloadPath(path.Join(folderName, albumInfo.FormatFolders[format]), item.Url)
 			}
+			for i := range albumInfo.Tracks {
+				if t := &albumInfo.Tracks[i]; t.PageUrl == item.PageUrl {
+					t.SongUrl = make(map[string]string, len(downloads))
+					for format, download := range downloads {
+						t.SongUrl[format] = download.Url
+					}
+				}
+			}
+		case PlanImage:
+			if rescrapedAlbum == nil {
+				rescrapedAlbum, err = FetchAlbumInfo(ctx, httpClient, albumInfo.Url)
+				if err != nil {
+					return err
+				}
+			}
+			found := false
+			for _, imgInfo := range rescrapedAlbum.Images {
+				if downloadPath(folderName, imgInfo.ImageUrl) == item.Path {
+					item.Url, found = imgInfo.ImageUrl, true
+				}
+			}
+			if !found {
+				return fmt.Errorf("image %s no longer found on album page", item.Path)
+			}
+			albumInfo.Images = rescrapedAlbum.Images
+		}
+		return nil
+	}
+
+	// Downloads into a .part file first, which is resumed if left by an interrupted run
+	download := func(item *PlanItem) error {
+		logger.Info("downloading from " + item.Url)
+		partPath := item.Path + ".part"
+		var offset int64
+		if info, err := osStat(partPath); err == nil && info != nil {
+			offset = info.Size()
 		}
-		body, err := getUrl(ctx, httpClient, unescaped)
+		unescaped, _ := URL.QueryUnescape(item.Url)
+		body, resumed, err := getUrlFrom(ctx, httpClient, unescaped, offset)
 		if err != nil {
-			slog.Error("failed to download " + kind + ": " + err.Error())
-			return
+			return fmt.Errorf("failed to download %s: %w", item.Kind, err)
 		}
 		defer body.Close()
-		file, err := osCreate(fileName)
+		if dir := path.Dir(item.Path); dir != folderName {
+			if err := osMkdirAll(dir, os.ModePerm); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		}
+		var file io.WriteCloser
+		if resumed {
+			logger.Info("resuming "+item.Path, "offset", offset)
+			file, err = osAppend(partPath)
+		} else {
+			offset = 0
+			file, err = osCreate(partPath)
+		}
 		if err != nil {
-			slog.Error("failed to create " + kind + " file: " + err.Error())
-			return
+			return fmt.Errorf("failed to create %s file: %w", item.Kind, err)
+		}
+		written, err := io.Copy(file, body)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
 		}
-		defer file.Close()
-		_, err = io.Copy(file, body)
 		if err != nil {
-			slog.Error("failed to write " + kind + " file: " + err.Error())
-			return
+			return fmt.Errorf("failed to write %s file: %w", item.Kind, err)
 		}
-	}
-
-	if !noDownloadImage {
-		for _, imgInfo := range albumInfo.Images {
-			download(imgInfo.ImageUrl, "image")
+		if err := osRename(partPath, item.Path); err != nil {
+			return fmt.Errorf("failed to rename %s file: %w", item.Kind, err)
 		}
-		if len(albumInfo.Images) == 0 {
-			logger.Info("no images found")
+		// Expected sizes are rounded for display, so only large differences are suspicious
+		if written += offset; item.Size > 0 && (written < item.Size/2 || written > item.Size*2) {
+			logger.Warn("downloaded file size differs from the expected size", "file", item.Path, "size", FormatByteSize(written), "expected", FormatByteSize(item.Size))
 		}
+		return nil
 	}
 
-	if !noDownloadTrack {
-		for i := range albumInfo.Tracks {
-			t := &albumInfo.Tracks[i]
-			if !trackNumberSet.Contains(t) {
-				slog.Debug("skipping track " + t.Name)
-				continue
+	var itemErrs DownloadErrors
+	fail := func(item *PlanItem, err error) {
+		name := item.Path
+		if name == "" {
+			name = item.Name
+		}
+		logger.Error(err.Error(), "file", name)
+		item.Error = err.Error()
+		itemErrs = append(itemErrs, &ItemError{Path: name, Err: err})
+	}
+	for i := range plan.Items {
+		if ctx.Err() != nil {
+			break
+		}
+		item := &plan.Items[i]
+		if item.SkipReason != "" {
+			if err := skipError(item); err != nil {
+				fail(item, err)
 			}
-			trackUrl, err := FetchTrackDownloadUrl(ctx, httpClient, t.PageUrl)
-			if err != nil {
-				slog.Error("failed to fetch track download url: " + err.Error())
+			continue
+		}
+		if item.Url == "" {
+			if err := rescrape(item); err != nil {
+				fail(item, fmt.Errorf("failed to fetch track download url: %w", err))
 				continue
 			}
-			t.SongUrl = trackUrl
-			if u, ok := trackFormatRanking.GetFrom(trackUrl); ok {
-				download(u, "track")
+			if skipExisting(logger, osStat, item, overwrite); item.SkipReason != "" {
 				continue
 			}
-			logger.Info("no preferred format found for " + t.Name)
 		}
-		if len(albumInfo.Tracks) == 0 {
-			logger.Info("no tracks found")
+		err := download(item)
+		if errors.Is(err, ErrNotFound) {
+			logger.Info("re-scraping " + item.Path + " as its URL is gone")
+			if rescrapeErr := rescrape(item); rescrapeErr != nil {
+				err = fmt.Errorf("%w, re-scraping failed: %w", err, rescrapeErr)
+			} else {
+				err = download(item)
+			}
+		}
+		if err == nil {
+			item.Downloaded = true
+		} else if ctx.Err() == nil {
+			fail(item, err)
 		}
 	}
+	albumInfo.Status = StatusComplete
+	if ctx.Err() != nil {
+		logger.Warn("interrupted, partial files are kept to be resumed")
+		albumInfo.Status = StatusInterrupted
+	} else if len(itemErrs) > 0 {
+		albumInfo.Status = StatusIncomplete
+	}
 
 	if !noCreateInfo {
 		logger.Info("writing album info")
 		if summaryFile, err := osCreate(path.Join(folderName, "info.json")); err != nil {
-			slog.Error("failed to create album info file: " + err.Error())
+			fail(&PlanItem{Path: path.Join(folderName, "info.json")}, fmt.Errorf("failed to create album info file: %w", err))
 		} else {
 			defer summaryFile.Close()
 			encoder := json.NewEncoder(summaryFile)
@@ -336,18 +551,33 @@ func fetchAlbum(
 	if !noCreateShortcut {
 		logger.Info("writing shortcut file")
 		if lnkFile, err := osCreate(path.Join(folderName, "page.url")); err != nil {
-			slog.Error("failed to create lnk file: " + err.Error())
+			fail(&PlanItem{Path: path.Join(folderName, "page.url")}, fmt.Errorf("failed to create lnk file: %w", err))
 		} else {
 			defer lnkFile.Close()
 			lnkFile.Write([]byte("[{000214A0-0000-0000-C000-000000000046}]\r\n"))
 			lnkFile.Write([]byte("Prop3=19,11\r\n"))
 			lnkFile.Write([]byte("[InternetShortcut]\r\n"))
 			lnkFile.Write([]byte("IDList=\r\n"))
-			lnkFile.Write([]byte("URL=" + albumUrl + "\r\n"))
+			lnkFile.Write([]byte("URL=" + albumInfo.Url + "\r\n"))
 		}
 	}
 
-	return albumInfo, folderName, nil
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if len(itemErrs) > 0 {
+		return itemErrs
+	}
+	return nil
+}
+
+func osCreateFile(name string) (io.WriteCloser, error) {
+	return os.Create(name) // covariance
+}
+
+// Opens an existing file to write at its end
+func osAppendFile(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
 }
 
 func FetchAlbum(
@@ -363,40 +593,64 @@ func FetchAlbum(
 	overwrite bool,
 	trackNumberSet TrackNumberSet,
 	trackFormatRanking TrackFormatRanking,
-) (*AlbumInfo, string, error) {
-	osCreate := func(name string) (io.WriteCloser, error) {
-		return os.Create(name) // covariance
-	}
-	return fetchAlbum(ctx, httpClient, logger, os.MkdirAll, osCreate, os.Stat, workPath, albumUrl, noDownloadImage, noDownloadTrack, noCreateInfo, noCreateShortcut, overwrite, trackNumberSet, trackFormatRanking)
+	formats []string,
+	maxSize int64,
+	downgrade bool,
+) (*DownloadPlan, error) {
+	return fetchAlbum(ctx, httpClient, logger, os.MkdirAll, osCreateFile, osAppendFile, os.Rename, os.Stat, DiskFreeSpace, workPath, albumUrl, noDownloadImage, noDownloadTrack, noCreateInfo, noCreateShortcut, overwrite, trackNumberSet, trackFormatRanking, formats, maxSize, downgrade)
 }
 
-type TrackFormatRanking = MapPreferenceAccessor[string]
-
-var DownloadAllTracks = TrackNumberSet{TrackNumberKey{"*", "*"}: {}}
-
-type TrackNumberKey struct{ DiscNumber, TrackNumber string }
-type TrackNumberSet map[TrackNumberKey]struct{}
-
-func (s TrackNumberSet) Contains(info *TrackInfo) bool {
-	disc := strings.TrimLeft(info.DiscNumber, "0")
-	track := strings.TrimLeft(info.TrackNumber, "0")
-	if _, ok := s[TrackNumberKey{"*", "*"}]; ok {
-		return true
-	} else if _, ok := s[TrackNumberKey{disc, "*"}]; ok {
-		return true
-	} else if _, ok := s[TrackNumberKey{"*", track}]; ok {
-		return true
+// Downloads an album into folder using the URLs recorded in its info.json. The folder is usually
+// AlbumFolder, or the existing folder of an earlier download
+func FetchAlbumFromInfo(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	folder string,
+	albumInfo *AlbumInfo,
+	noDownloadImage,
+	noDownloadTrack,
+	noCreateInfo,
+	noCreateShortcut,
+	overwrite bool,
+	trackNumberSet TrackNumberSet,
+	trackFormatRanking TrackFormatRanking,
+	formats []string,
+	maxSize int64,
+	downgrade bool,
+) (*DownloadPlan, error) {
+	plan := planFromAlbumInfo(logger, newAudioProber(ctx, httpClient), os.Stat, folder, albumInfo, noDownloadImage, noDownloadTrack, overwrite, trackNumberSet, trackFormatRanking, formats)
+	if err := enforceBudget(ctx, httpClient, logger, DiskFreeSpace, plan, filepath.Dir(folder), maxSize, downgrade); err != nil {
+		return nil, err
 	}
-	_, ok := s[TrackNumberKey{disc, track}]
-	return ok
+	err := executePlan(ctx, httpClient, logger, os.MkdirAll, osCreateFile, osAppendFile, os.Rename, os.Stat, plan, trackFormatRanking, noCreateInfo, noCreateShortcut, overwrite)
+	return plan, err
 }
 
-func (s TrackNumberSet) Add(key TrackNumberKey) {
-	disc := strings.TrimLeft(key.DiscNumber, "0")
-	track := strings.TrimLeft(key.TrackNumber, "0")
-	s[TrackNumberKey{disc, track}] = struct{}{}
+// Downloads the items of a plan printed by -plan as is
+func FetchAlbumFromPlan(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	plan *DownloadPlan,
+	noCreateInfo,
+	noCreateShortcut,
+	overwrite bool,
+	maxSize int64,
+	downgrade bool,
+) (*DownloadPlan, error) {
+	if plan.Album == nil {
+		return nil, fmt.Errorf("plan has no album")
+	}
+	if err := enforceBudget(ctx, httpClient, logger, DiskFreeSpace, plan, path.Dir(plan.Folder), maxSize, downgrade); err != nil {
+		return nil, err
+	}
+	err := executePlan(ctx, httpClient, logger, os.MkdirAll, osCreateFile, osAppendFile, os.Rename, os.Stat, plan, nil, noCreateInfo, noCreateShortcut, overwrite)
+	return plan, err
 }
 
+type TrackFormatRanking = MapPreferenceAccessor[string]
+
 type MapPreferenceAccessor[V any] []string
 
 func (pa MapPreferenceAccessor[V]) GetFrom(m map[string]V) (V, bool) {
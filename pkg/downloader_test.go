@@ -3,6 +3,7 @@ package pkg
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"io"
 	"log/slog"
 	"net/http"
@@ -51,6 +52,60 @@ func (m FSRecorder) Create(name string) (io.WriteCloser, error) {
 	}, nil
 }
 
+func (m FSRecorder) Append(name string) (io.WriteCloser, error) {
+	if _, ok := m[name]; !ok {
+		return nil, os.ErrNotExist
+	}
+	content := m[name]
+	w, _ := m.Create(name)
+	m[name] = content
+	return w, nil
+}
+
+func (m FSRecorder) Rename(oldName, newName string) error {
+	content, ok := m[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m, oldName)
+	m[newName] = content
+	return nil
+}
+
+func (m FSRecorder) Open(name string) (io.ReadCloser, error) {
+	content, ok := m[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+func (m FSRecorder) Remove(name string) error {
+	if _, ok := m[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m, name)
+	return nil
+}
+
+type sizeFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi sizeFileInfo) Size() int64 {
+	return fi.size
+}
+
+// Reports the recorded files only
+func (m FSRecorder) Stat(name string) (os.FileInfo, error) {
+	content, ok := m[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return sizeFileInfo{size: int64(len(content))}, nil
+}
+
 var (
 	//go:embed testdata/album1_home.html
 	home1 string
@@ -63,12 +118,13 @@ var (
 func TestFetchAlbum(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
 	formatPref := TrackFormatRanking{"FLAC", "MP3"}
+	mkDiskFreeSpace := func(string) (int64, error) { return 1 << 40, nil }
 
 	t.Run("title not found exit early", func(t *testing.T) {
 		client := stubClient{
 			".": {"GET": {http.StatusOK, "<div></div>"}},
 		}
-		_, _, err := fetchAlbum(context.Background(), client, logger, nil, nil, nil, ".", ".", false, false, false, false, false, DownloadAllTracks, formatPref)
+		_, err := fetchAlbum(context.Background(), client, logger, nil, nil, nil, nil, nil, nil, ".", ".", false, false, false, false, false, DownloadAllTracks, formatPref, nil, 0, false)
 		if err == nil || !strings.Contains(err.Error(), "album name") {
 			t.Fatalf("expected error, got nil")
 		}
@@ -93,12 +149,12 @@ func TestFetchAlbum(t *testing.T) {
 			return nil, os.ErrNotExist
 		}
 
-		res, folder, err := fetchAlbum(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkStat, ".", "https://example.com/", false, false, false, false, true, DownloadAllTracks, formatPref)
+		plan, err := fetchAlbum(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkStat, mkDiskFreeSpace, ".", "https://example.com/", false, false, false, false, true, DownloadAllTracks, formatPref, nil, 0, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if folder != "My Album 1" {
-			t.Fatalf("expected folder to be My Album 1, got %s", folder)
+		if plan.Folder != "My Album 1" {
+			t.Fatalf("expected folder to be My Album 1, got %s", plan.Folder)
 		}
 		expAlbumInfo := AlbumInfo{
 			Url:              "https://example.com/",
@@ -111,6 +167,9 @@ func TestFetchAlbum(t *testing.T) {
 			CatalogNumber:    []string{},
 			AlbumType:        []string{"Soundtrack"},
 			Description:      "This is a description of my album.",
+			NumberOfFiles:    2,
+			TotalFilesize:    1 << 20,
+			DateAdded:        "Jan 18th, 2024",
 			Images: []ImageInfo{
 				{
 					ImageUrl: "https://download.com/Cover.jpg",
@@ -126,6 +185,8 @@ func TestFetchAlbum(t *testing.T) {
 						"FLAC": "https://download.com/01.%20song1.flac",
 						"MP3":  "https://download.com/01.%20song1.mp3",
 					},
+					Duration: "4:27",
+					Sizes:    map[string]int64{"MP3": 1 << 20},
 				},
 				{
 					Name:        "song2",
@@ -135,11 +196,14 @@ func TestFetchAlbum(t *testing.T) {
 						"FLAC": "https://download.com/02.%20song2.flac",
 						"MP3":  "https://download.com/02.%20song2.mp3",
 					},
+					Duration: "4:27",
+					Sizes:    map[string]int64{"MP3": 1 << 20},
 				},
 			},
+			Status: StatusComplete,
 		}
-		if !reflect.DeepEqual(*res, expAlbumInfo) {
-			t.Fatalf("expected %v, got %v", expAlbumInfo, *res)
+		if !reflect.DeepEqual(*plan.Album, expAlbumInfo) {
+			t.Fatalf("expected %v, got %v", expAlbumInfo, *plan.Album)
 		}
 
 		// Check the downloaded files
@@ -187,9 +251,10 @@ func TestFetchAlbum(t *testing.T) {
 			return nil, os.ErrNotExist
 		}
 
-		res, _, err := fetchAlbum(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkStat, ".", "https://example.com/", false, false, false, true, false, DownloadAllTracks, formatPref)
-		if err != nil {
-			t.Fatalf("unexpected error: %v", err)
+		plan, err := fetchAlbum(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkStat, mkDiskFreeSpace, ".", "https://example.com/", false, false, false, true, false, DownloadAllTracks, formatPref, nil, 0, false)
+		var downloadErrs DownloadErrors
+		if !errors.As(err, &downloadErrs) || len(downloadErrs) != 1 || downloadErrs[0].Path != "My Album 2/1-01. song1.flac" || !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected a not found error for song1, got %v", err)
 		}
 
 		expAlbumInfo := AlbumInfo{
@@ -203,6 +268,9 @@ func TestFetchAlbum(t *testing.T) {
 			Publisher:        []string{"My Publisher"},
 			AlbumType:        []string{"Arrangement"},
 			Description:      "",
+			NumberOfFiles:    2,
+			TotalFilesize:    1 << 20,
+			DateAdded:        "Jan 18th, 2024",
 			Images: []ImageInfo{
 				{
 					ImageUrl: "https://download.com/Cover.jpg",
@@ -219,6 +287,8 @@ func TestFetchAlbum(t *testing.T) {
 						"FLAC": "https://download.com/1-01.%20song1.flac",
 						"MP3":  "https://download.com/1-01.%20song1.mp3",
 					},
+					Duration: "4:27",
+					Sizes:    map[string]int64{"MP3": 1 << 20, "FLAC": 2 << 20},
 				},
 				{
 					Name:        "song2",
@@ -229,10 +299,13 @@ func TestFetchAlbum(t *testing.T) {
 						"FLAC": "https://download.com/1-02.%20song2.flac",
 						"MP3":  "https://download.com/1-02.%20song2.mp3",
 					},
+					Duration: "4:27",
+					Sizes:    map[string]int64{"MP3": 1 << 20, "FLAC": 2 << 20},
 				}},
+			Status: StatusIncomplete,
 		}
-		if !reflect.DeepEqual(*res, expAlbumInfo) {
-			t.Fatalf("expected %v, got %v", expAlbumInfo, *res)
+		if !reflect.DeepEqual(*plan.Album, expAlbumInfo) {
+			t.Fatalf("expected %v, got %v", expAlbumInfo, *plan.Album)
 		}
 
 		// Check the downloaded files
@@ -272,7 +345,7 @@ func TestFetchAlbum(t *testing.T) {
 		set := TrackNumberSet{}
 		set.Add(TrackNumberKey{"01", "002"})
 
-		_, _, err := fetchAlbum(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkStat, ".", "https://example.com/", true, false, true, true, true, set, formatPref)
+		_, err := fetchAlbum(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkStat, mkDiskFreeSpace, ".", "https://example.com/", true, false, true, true, true, set, formatPref, nil, 0, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
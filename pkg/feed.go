@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Fetches a page listing albums, such as the recently added albums. Only the fields shown in the
+// list are filled in: Url, Name, Platforms, AlbumType, Year and the thumbnail
+func FetchAlbumList(ctx context.Context, httpClient HttpDoClient, listUrl string) ([]AlbumInfo, error) {
+	body, err := getUrl(ctx, httpClient, listUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch album list: %w", err)
+	}
+	defer body.Close()
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse html file for album list: %w", err)
+	}
+
+	header := doc.Find("#pageContent table.albumList tr:has(th)").First()
+	albumIndex := header.Find("th:contains('Album')").Index()
+	platformIndex := header.Find("th:contains('Platform')").Index()
+	typeIndex := header.Find("th:contains('Type')").Index()
+	yearIndex := header.Find("th:contains('Year')").Index()
+	if albumIndex < 0 {
+		return nil, fmt.Errorf("failed to find album list")
+	}
+	values := func(s *goquery.Selection) []string {
+		res := []string{}
+		for v := range strings.SplitSeq(s.Text(), ",") {
+			if v = strings.TrimSpace(v); v != "" && v != "N/A" {
+				res = append(res, v)
+			}
+		}
+		return res
+	}
+
+	result := []AlbumInfo{}
+	doc.Find("#pageContent table.albumList tr:has(td)").Each(func(i int, s *goquery.Selection) {
+		albumInfo := AlbumInfo{Platforms: []string{}, AlbumType: []string{}, Year: []string{}, Images: []ImageInfo{}}
+		s.Find("td").Each(func(j int, s *goquery.Selection) {
+			switch j {
+			case albumIndex:
+				albumInfo.Name = strings.TrimSpace(s.Text())
+				if href, ok := s.Find("a").Attr("href"); ok {
+					albumInfo.Url, _ = joinUrl(listUrl, href)
+				}
+			case platformIndex:
+				albumInfo.Platforms = values(s)
+			case typeIndex:
+				albumInfo.AlbumType = values(s)
+			case yearIndex:
+				albumInfo.Year = values(s)
+			}
+		})
+		if src, ok := s.Find("td.albumIcon img").Attr("src"); ok {
+			thumbUrl, _ := joinUrl(listUrl, src)
+			albumInfo.Images = append(albumInfo.Images, ImageInfo{ThumbUrl: thumbUrl})
+		}
+		if albumInfo.Url != "" {
+			result = append(result, albumInfo)
+		}
+	})
+	return result, nil
+}
+
+// Albums to download from a feed. Each non-empty list must have a value matching the album,
+// ignoring case. Keywords are searched in the names of the album
+type FeedFilter struct {
+	Platforms  []string
+	AlbumTypes []string
+	Years      []string
+	Keywords   []string
+}
+
+func (f FeedFilter) Matches(albumInfo *AlbumInfo) bool {
+	anyEqual := func(wanted, values []string) bool {
+		return len(wanted) == 0 || slices.ContainsFunc(wanted, func(w string) bool {
+			return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, w) })
+		})
+	}
+	names := strings.ToLower(strings.Join(append([]string{albumInfo.Name}, albumInfo.AlternativeNames...), "\n"))
+	keywordFound := len(f.Keywords) == 0 || slices.ContainsFunc(f.Keywords, func(k string) bool {
+		return strings.Contains(names, strings.ToLower(k))
+	})
+	return keywordFound &&
+		anyEqual(f.Platforms, albumInfo.Platforms) &&
+		anyEqual(f.AlbumTypes, albumInfo.AlbumType) &&
+		anyEqual(f.Years, albumInfo.Year)
+}
+
+// $XDG_CONFIG_HOME/soundtrack-downloader/feed-seen.txt or equivalent on other platforms
+func DefaultFeedSeenPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "soundtrack-downloader", "feed-seen.txt")
+}
+
+func readSeenUrls(r io.Reader) (map[string]bool, error) {
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			seen[line] = true
+		}
+	}
+	return seen, scanner.Err()
+}
+
+// Reads the album URLs already seen in feeds, one per line. A missing file means none
+func LoadSeenUrls(name string) (map[string]bool, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readSeenUrls(f)
+}
+
+func AppendSeenUrls(name string, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(strings.Join(urls, "\n") + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go:embed testdata/latest.html
+var latest string
+
+func TestFetchAlbumList(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		client := stubClient{
+			"https://example.com/latest": {"GET": {http.StatusOK, latest}},
+		}
+		albums, err := FetchAlbumList(context.Background(), client, "https://example.com/latest")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []AlbumInfo{
+			{Url: "https://example.com/my-album-1", Name: "My Album 1", Platforms: []string{"MacOS", "Windows"}, AlbumType: []string{"Soundtrack"}, Year: []string{"2002"}, Images: []ImageInfo{{ThumbUrl: "https://download.com/thumbs_small/Cover1.jpg"}}},
+			{Url: "https://example.com/my-album-2", Name: "My Album 2", Platforms: []string{"MacOS", "Windows"}, AlbumType: []string{"Arrangement"}, Year: []string{"2002"}, Images: []ImageInfo{{ThumbUrl: "https://download.com/thumbs_small/Cover2.jpg"}}},
+			{Url: "https://example.com/other-game", Name: "Other Game Original Soundtrack", Platforms: []string{"Switch"}, AlbumType: []string{"Soundtrack", "Gamerip"}, Year: []string{"2024"}, Images: []ImageInfo{}},
+			{Url: "https://example.com/unknown", Name: "Unknown Album", Platforms: []string{}, AlbumType: []string{}, Year: []string{}, Images: []ImageInfo{}},
+		}
+		if !reflect.DeepEqual(albums, expected) {
+			t.Fatalf("expected %v, got %v", expected, albums)
+		}
+	})
+
+	t.Run("not an album list", func(t *testing.T) {
+		client := stubClient{
+			"https://example.com/": {"GET": {http.StatusOK, home1}},
+		}
+		if _, err := FetchAlbumList(context.Background(), client, "https://example.com/"); err == nil || !strings.Contains(err.Error(), "album list") {
+			t.Fatalf("expected error, got %v", err)
+		}
+	})
+}
+
+func TestFeedFilter(t *testing.T) {
+	album := &AlbumInfo{Name: "Other Game Original Soundtrack", AlternativeNames: []string{"Andere Spiel"}, Platforms: []string{"Switch"}, AlbumType: []string{"Soundtrack", "Gamerip"}, Year: []string{"2024"}}
+	tests := []struct {
+		filter   FeedFilter
+		expected bool
+	}{
+		{FeedFilter{}, true},
+		{FeedFilter{Platforms: []string{"windows", "switch"}, AlbumTypes: []string{"gamerip"}}, true},
+		{FeedFilter{Platforms: []string{"Windows"}}, false},
+		{FeedFilter{Years: []string{"2024"}, Keywords: []string{"spiel"}}, true},
+		{FeedFilter{Years: []string{"2023"}, Keywords: []string{"spiel"}}, false},
+		{FeedFilter{Keywords: []string{"zelda"}}, false},
+	}
+	for _, tt := range tests {
+		if res := tt.filter.Matches(album); res != tt.expected {
+			t.Fatalf("expected %v for %v, got %v", tt.expected, tt.filter, res)
+		}
+	}
+}
+
+func TestReadSeenUrls(t *testing.T) {
+	seen, err := readSeenUrls(strings.NewReader("https://example.com/my-album-1\n\nhttps://example.com/my-album-2\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]bool{"https://example.com/my-album-1": true, "https://example.com/my-album-2": true}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Fatalf("expected %v, got %v", expected, seen)
+	}
+}
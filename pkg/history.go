@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	FileDownloaded = "downloaded"
+	FileSkipped    = "skipped"
+	FileFailed     = "failed"
+	// Not attempted because the download was interrupted
+	FilePending = "pending"
+)
+
+// Download of an album, appended to the history file after each run
+type HistoryEntry struct {
+	Url    string
+	Name   string
+	Folder string
+	// Folder is the archive the album was downloaded into, which can't be added to
+	Archive bool `json:",omitzero"`
+	Time    time.Time
+	// Download flags given to the command, by name
+	Options map[string]string `json:",omitzero"`
+	// Status of the album info
+	Status string
+	// Every image and track was selected, and was downloaded or already existed. Runs such as
+	// with -no-download or -track have a complete status without being complete downloads
+	Complete bool          `json:",omitzero"`
+	Files    []HistoryFile `json:",omitzero"`
+}
+
+type HistoryFile struct {
+	// Path of the file, or the track name if it was not resolved
+	Path    string
+	Format  string `json:",omitzero"`
+	Outcome string
+	// Skip reason or error
+	Reason string `json:",omitzero"`
+}
+
+// The folder is made absolute so that it is found from any working directory
+func NewHistoryEntry(plan *DownloadPlan, options map[string]string, now time.Time) HistoryEntry {
+	folder := plan.Folder
+	if plan.Archive != "" {
+		folder = plan.Archive
+	}
+	if abs, err := filepath.Abs(folder); err == nil {
+		folder = abs
+	}
+	entry := HistoryEntry{
+		Url:     plan.Album.Url,
+		Name:    plan.Album.Name,
+		Folder:  folder,
+		Archive: plan.Archive != "",
+		Time:    now,
+		Options: options,
+		Status:  plan.Album.Status,
+	}
+	entry.Complete = plan.Album.Status == StatusComplete
+	for _, item := range plan.Items {
+		if !item.Downloaded && item.SkipReason != SkipExists && item.SkipReason != SkipFormatUnavailable {
+			entry.Complete = false
+		}
+		file := HistoryFile{Path: item.Path, Format: item.Format}
+		if file.Path == "" {
+			file.Path = item.Name
+		}
+		switch {
+		case item.Downloaded:
+			file.Outcome = FileDownloaded
+		case item.Error != "":
+			file.Outcome, file.Reason = FileFailed, item.Error
+		case item.SkipReason != "":
+			file.Outcome, file.Reason = FileSkipped, item.SkipReason
+		default:
+			file.Outcome = FilePending
+		}
+		entry.Files = append(entry.Files, file)
+	}
+	return entry
+}
+
+// $XDG_CONFIG_HOME/soundtrack-downloader/history.jsonl or equivalent on other platforms
+func DefaultHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "soundtrack-downloader", "history.jsonl")
+}
+
+func writeHistoryEntry(w io.Writer, entry HistoryEntry) error {
+	return json.NewEncoder(w).Encode(entry)
+}
+
+// Reads a history file of one JSON entry per line, oldest first
+func ReadHistory(r io.Reader) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid history entry at line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func AppendHistory(name string, entry HistoryEntry) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := writeHistoryEntry(f, entry); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Reads the history file. A missing file is an empty history
+func LoadHistory(name string) ([]HistoryEntry, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadHistory(f)
+}
+
+func sameAlbumUrl(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// Latest download of the album, found by URL so that moved folders are still recognised
+func LastDownload(entries []HistoryEntry, url string) *HistoryEntry {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if sameAlbumUrl(entries[i].Url, url) {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+// Downloads of the album, oldest first
+func AlbumHistory(entries []HistoryEntry, url string) []HistoryEntry {
+	var res []HistoryEntry
+	for _, entry := range entries {
+		if sameAlbumUrl(entry.Url, url) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
+
+// Entries whose URL, name or folder contains query, ignoring case
+func SearchHistory(entries []HistoryEntry, query string) []HistoryEntry {
+	query = strings.ToLower(query)
+	var res []HistoryEntry
+	for _, entry := range entries {
+		if strings.Contains(strings.ToLower(entry.Url), query) ||
+			strings.Contains(strings.ToLower(entry.Name), query) ||
+			strings.Contains(strings.ToLower(entry.Folder), query) {
+			res = append(res, entry)
+		}
+	}
+	return res
+}
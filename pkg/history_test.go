@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHistoryEntry(t *testing.T) {
+	now := time.Date(2024, 1, 18, 12, 0, 0, 0, time.UTC)
+	plan := &DownloadPlan{Album: &AlbumInfo{Url: "https://example.com/", Name: "My Album", Status: StatusInterrupted}, Folder: "My Album", Items: []PlanItem{
+		{Kind: PlanImage, Path: "My Album/Cover.jpg", SkipReason: SkipExists},
+		{Kind: PlanTrack, Name: "song1", Path: "My Album/01.flac", Format: "FLAC", Downloaded: true},
+		{Kind: PlanTrack, Name: "song2", Path: "My Album/02.flac", Format: "FLAC", Error: "failed to download track: unexpected response: 500"},
+		{Kind: PlanTrack, Name: "song3"},
+	}}
+
+	entry := NewHistoryEntry(plan, map[string]string{"formats": "FLAC"}, now)
+	folder, _ := filepath.Abs("My Album")
+	expected := HistoryEntry{
+		Url:     "https://example.com/",
+		Name:    "My Album",
+		Folder:  folder,
+		Time:    now,
+		Options: map[string]string{"formats": "FLAC"},
+		Status:  StatusInterrupted,
+		Files: []HistoryFile{
+			{Path: "My Album/Cover.jpg", Outcome: FileSkipped, Reason: SkipExists},
+			{Path: "My Album/01.flac", Format: "FLAC", Outcome: FileDownloaded},
+			{Path: "My Album/02.flac", Format: "FLAC", Outcome: FileFailed, Reason: "failed to download track: unexpected response: 500"},
+			{Path: "song3", Outcome: FilePending},
+		},
+	}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Fatalf("expected %v, got %v", expected, entry)
+	}
+
+	t.Run("complete only with all files", func(t *testing.T) {
+		tests := []struct {
+			items    []PlanItem
+			expected bool
+		}{
+			{[]PlanItem{{Kind: PlanImage, SkipReason: SkipExists}, {Kind: PlanTrack, Downloaded: true}, {Kind: PlanTrack, Format: "MP3", SkipReason: SkipFormatUnavailable}}, true},
+			{[]PlanItem{{Kind: PlanImage, SkipReason: SkipDisabled}, {Kind: PlanTrack, Downloaded: true}}, false},
+			{[]PlanItem{{Kind: PlanImage, Downloaded: true}, {Kind: PlanTrack, SkipReason: SkipNotSelected}}, false},
+		}
+		for _, tt := range tests {
+			plan := &DownloadPlan{Album: &AlbumInfo{Status: StatusComplete}, Items: tt.items}
+			if entry := NewHistoryEntry(plan, nil, now); entry.Complete != tt.expected {
+				t.Fatalf("expected %v for %v, got %v", tt.expected, tt.items, entry.Complete)
+			}
+		}
+	})
+
+	t.Run("happy path archive", func(t *testing.T) {
+		plan := &DownloadPlan{Album: &AlbumInfo{Status: StatusComplete}, Folder: "My Album", Archive: "My Album.zip"}
+		archive, _ := filepath.Abs("My Album.zip")
+		if entry := NewHistoryEntry(plan, nil, now); entry.Folder != archive || !entry.Archive {
+			t.Fatalf("expected %v, got %v %v", archive, entry.Folder, entry.Archive)
+		}
+	})
+}
+
+func TestHistory(t *testing.T) {
+	var buf bytes.Buffer
+	entries := []HistoryEntry{
+		{Url: "https://example.com/album1", Name: "My Album 1", Folder: "My Album 1", Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Status: StatusIncomplete},
+		{Url: "https://example.com/album2", Name: "Other", Folder: "music/Other", Time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Status: StatusComplete},
+		{Url: "https://example.com/album1/", Name: "My Album 1", Folder: "moved/My Album 1", Time: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Status: StatusComplete},
+	}
+	for _, entry := range entries {
+		if err := writeHistoryEntry(&buf, entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	t.Run("happy path reads written entries", func(t *testing.T) {
+		res, err := ReadHistory(strings.NewReader(buf.String() + "\n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(res, entries) {
+			t.Fatalf("expected %v, got %v", entries, res)
+		}
+	})
+
+	t.Run("happy path finds the last download by url", func(t *testing.T) {
+		last := LastDownload(entries, "https://example.com/album1")
+		if last == nil || last.Folder != "moved/My Album 1" {
+			t.Fatalf("expected the moved folder, got %v", last)
+		}
+		if last := LastDownload(entries, "https://example.com/album3"); last != nil {
+			t.Fatalf("expected nil, got %v", last)
+		}
+		if res := AlbumHistory(entries, "https://example.com/album1/"); len(res) != 2 {
+			t.Fatalf("expected 2 entries, got %v", res)
+		}
+	})
+
+	t.Run("happy path searches names and folders", func(t *testing.T) {
+		if res := SearchHistory(entries, "my album"); len(res) != 2 {
+			t.Fatalf("expected 2 entries, got %v", res)
+		}
+		if res := SearchHistory(entries, "MUSIC/"); len(res) != 1 || res[0].Name != "Other" {
+			t.Fatalf("expected Other, got %v", res)
+		}
+	})
+
+	t.Run("invalid line", func(t *testing.T) {
+		if _, err := ReadHistory(strings.NewReader(buf.String() + "{\n")); err == nil || !strings.Contains(err.Error(), "line 4") {
+			t.Fatalf("expected error at line 4, got %v", err)
+		}
+	})
+}
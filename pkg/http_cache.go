@@ -0,0 +1,261 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrNotCached = errors.New("not in cache")
+
+type httpCacheEntry struct {
+	Url          string
+	StoredAt     time.Time
+	ContentType  string `json:",omitzero"`
+	ETag         string `json:",omitzero"`
+	LastModified string `json:",omitzero"`
+	// Checks that the body belongs to this metadata
+	BodySha256 string
+}
+
+func bodySha256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Wraps a client to store HTML pages on disk. Fresh pages are served from the cache, stale ones
+// are revalidated with ETag and Last-Modified. In offline mode only the cache is read
+type CachingClient struct {
+	client      HttpDoClient
+	osMkdirAll  func(string, os.FileMode) error
+	osReadFile  func(string) ([]byte, error)
+	osWriteFile func(string, []byte, os.FileMode) error
+	osRename    func(string, string) error
+	dir         string
+	ttl         time.Duration
+	offline     bool
+	now         func() time.Time
+}
+
+func newCachingClient(
+	client HttpDoClient,
+	osMkdirAll func(string, os.FileMode) error,
+	osReadFile func(string) ([]byte, error),
+	osWriteFile func(string, []byte, os.FileMode) error,
+	osRename func(string, string) error,
+	dir string,
+	ttl time.Duration,
+	offline bool,
+) *CachingClient {
+	return &CachingClient{
+		client:      client,
+		osMkdirAll:  osMkdirAll,
+		osReadFile:  osReadFile,
+		osWriteFile: osWriteFile,
+		osRename:    osRename,
+		dir:         dir,
+		ttl:         ttl,
+		offline:     offline,
+		now:         time.Now,
+	}
+}
+
+func NewCachingClient(client HttpDoClient, dir string, ttl time.Duration, offline bool) *CachingClient {
+	return newCachingClient(client, os.MkdirAll, os.ReadFile, os.WriteFile, os.Rename, dir, ttl, offline)
+}
+
+// $XDG_CACHE_HOME/soundtrack-downloader or equivalent on other platforms
+func DefaultHttpCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "soundtrack-downloader"), nil
+}
+
+func (c *CachingClient) entryPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+func (c *CachingClient) load(url string) (*httpCacheEntry, []byte, error) {
+	base := c.entryPath(url)
+	metaContent, err := c.osReadFile(base + ".json")
+	if err != nil {
+		return nil, nil, err
+	}
+	var entry httpCacheEntry
+	if err := json.Unmarshal(metaContent, &entry); err != nil {
+		return nil, nil, err
+	}
+	body, err := c.osReadFile(base + ".body")
+	if err != nil {
+		return nil, nil, err
+	}
+	if bodySha256(body) != entry.BodySha256 {
+		return nil, nil, fmt.Errorf("cache entry of %s is being written or corrupted", url)
+	}
+	return &entry, body, nil
+}
+
+// Writes to temporary files first and the metadata last. The body and metadata are replaced one
+// after the other, so readers check the body against the hash in the metadata
+func (c *CachingClient) store(entry *httpCacheEntry, body []byte) error {
+	if err := c.osMkdirAll(c.dir, os.ModePerm); err != nil {
+		return err
+	}
+	entry.BodySha256 = bodySha256(body)
+	metaContent, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	base := c.entryPath(entry.Url)
+	for _, f := range []struct {
+		ext     string
+		content []byte
+	}{{".body", body}, {".json", metaContent}} {
+		if err := c.osWriteFile(base+f.ext+".tmp", f.content, 0o644); err != nil {
+			return err
+		}
+		if err := c.osRename(base+f.ext+".tmp", base+f.ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cachedResponse(req *http.Request, entry *httpCacheEntry, body []byte) *http.Response {
+	header := http.Header{"X-Cache": {"HIT"}}
+	if entry.ContentType != "" {
+		header.Set("Content-Type", entry.ContentType)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func isHtmlResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/html"
+}
+
+func (c *CachingClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		if c.offline {
+			return nil, fmt.Errorf("%w: %s %s", ErrNotCached, req.Method, req.URL)
+		}
+		return c.client.Do(req)
+	}
+	url := req.URL.String()
+	entry, body, err := c.load(url)
+	if err != nil && c.offline {
+		return nil, fmt.Errorf("%w: %s", ErrNotCached, url)
+	}
+	if err == nil && (c.offline || c.now().Sub(entry.StoredAt) < c.ttl) {
+		return cachedResponse(req, entry, body), nil
+	}
+
+	if entry != nil {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		resp.Body.Close()
+		entry.StoredAt = c.now()
+		_ = c.store(entry, body)
+		return cachedResponse(req, entry, body), nil
+	}
+	if resp.StatusCode != http.StatusOK || !isHtmlResponse(resp) {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	entry = &httpCacheEntry{
+		Url:          url,
+		StoredAt:     c.now(),
+		ContentType:  resp.Header.Get("Content-Type"),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if err := c.store(entry, body); err != nil {
+		return nil, fmt.Errorf("failed to write cache: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func pruneHttpCache(
+	osReadDir func(string) ([]os.DirEntry, error),
+	osReadFile func(string) ([]byte, error),
+	osRemove func(string) error,
+	now time.Time,
+	dir string,
+	maxAge time.Duration,
+) (int, error) {
+	dirEntries, err := osReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, dirEntry := range dirEntries {
+		base, ok := strings.CutSuffix(dirEntry.Name(), ".json")
+		if !ok || dirEntry.IsDir() {
+			continue
+		}
+		base = filepath.Join(dir, base)
+		if maxAge > 0 {
+			var entry httpCacheEntry
+			content, err := osReadFile(base + ".json")
+			if err == nil && json.Unmarshal(content, &entry) == nil && now.Sub(entry.StoredAt) < maxAge {
+				continue
+			}
+		}
+		if err := osRemove(base + ".json"); err != nil {
+			return removed, err
+		}
+		if err := osRemove(base + ".body"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Removes entries stored longer than maxAge ago, or all entries if maxAge is 0.
+// Returns the number of removed entries
+func PruneHttpCache(dir string, maxAge time.Duration) (int, error) {
+	return pruneHttpCache(os.ReadDir, os.ReadFile, os.Remove, time.Now(), dir, maxAge)
+}
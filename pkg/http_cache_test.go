@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+type recordingClient struct {
+	requests []*http.Request
+	respond  func(*http.Request) *http.Response
+}
+
+func (c *recordingClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	return c.respond(req), nil
+}
+
+func htmlResponse(code int, content string, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	return &http.Response{StatusCode: code, Header: header, Body: io.NopCloser(strings.NewReader(content))}
+}
+
+// Files of the cache in memory
+type memFiles map[string][]byte
+
+func (m memFiles) MkdirAll(string, os.FileMode) error {
+	return nil
+}
+
+func (m memFiles) ReadFile(name string) ([]byte, error) {
+	content, ok := m[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return content, nil
+}
+
+func (m memFiles) WriteFile(name string, content []byte, perm os.FileMode) error {
+	m[name] = content
+	return nil
+}
+
+func (m memFiles) Rename(oldName, newName string) error {
+	content, ok := m[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m, oldName)
+	m[newName] = content
+	return nil
+}
+
+func newMemCachingClient(client HttpDoClient, files memFiles, offline bool) *CachingClient {
+	return newCachingClient(client, files.MkdirAll, files.ReadFile, files.WriteFile, files.Rename, "cache", time.Hour, offline)
+}
+
+func TestCachingClient(t *testing.T) {
+	get := func(c HttpDoClient, url string) (string, error) {
+		body, err := getUrl(context.Background(), c, url)
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		content, err := io.ReadAll(body)
+		return string(content), err
+	}
+
+	t.Run("happy path serves fresh pages from cache", func(t *testing.T) {
+		client := &recordingClient{respond: func(*http.Request) *http.Response {
+			return htmlResponse(http.StatusOK, "album page", nil)
+		}}
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := NewCachingClient(client, t.TempDir(), time.Hour, false)
+		c.now = clock.Now
+		for range 2 {
+			if content, err := get(c, "https://example.com/album"); err != nil || content != "album page" {
+				t.Fatalf("expected album page, got %s (err: %v)", content, err)
+			}
+		}
+		if len(client.requests) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(client.requests))
+		}
+	})
+
+	t.Run("happy path revalidates stale pages", func(t *testing.T) {
+		client := &recordingClient{respond: func(req *http.Request) *http.Response {
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				return &http.Response{StatusCode: http.StatusNotModified, Body: io.NopCloser(strings.NewReader(""))}
+			}
+			return htmlResponse(http.StatusOK, "album page", http.Header{"Etag": {`"v1"`}})
+		}}
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := NewCachingClient(client, t.TempDir(), time.Hour, false)
+		c.now = clock.Now
+		get(c, "https://example.com/album")
+		clock.now = clock.now.Add(2 * time.Hour)
+		if content, err := get(c, "https://example.com/album"); err != nil || content != "album page" {
+			t.Fatalf("expected album page, got %s (err: %v)", content, err)
+		}
+		// Entry is fresh again after revalidation
+		get(c, "https://example.com/album")
+		if len(client.requests) != 2 {
+			t.Fatalf("expected 2 requests, got %d", len(client.requests))
+		}
+	})
+
+	t.Run("happy path does not cache files and errors", func(t *testing.T) {
+		client := &recordingClient{respond: func(req *http.Request) *http.Response {
+			if strings.HasSuffix(req.URL.Path, ".flac") {
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"audio/flac"}}, Body: io.NopCloser(strings.NewReader("flac"))}
+			}
+			return htmlResponse(http.StatusNotFound, "not found", nil)
+		}}
+		c := NewCachingClient(client, t.TempDir(), time.Hour, false)
+		for range 2 {
+			get(c, "https://example.com/song.flac")
+			get(c, "https://example.com/missing")
+		}
+		if len(client.requests) != 4 {
+			t.Fatalf("expected 4 requests, got %d", len(client.requests))
+		}
+	})
+
+	t.Run("offline reads stale pages and fails on missing ones", func(t *testing.T) {
+		dir := t.TempDir()
+		client := &recordingClient{respond: func(*http.Request) *http.Response {
+			return htmlResponse(http.StatusOK, "album page", nil)
+		}}
+		online := NewCachingClient(client, dir, time.Hour, false)
+		online.now = (&fakeClock{now: time.Unix(0, 0)}).Now
+		get(online, "https://example.com/album")
+
+		offline := NewCachingClient(client, dir, time.Hour, true)
+		if content, err := get(offline, "https://example.com/album"); err != nil || content != "album page" {
+			t.Fatalf("expected album page, got %s (err: %v)", content, err)
+		}
+		if _, err := get(offline, "https://example.com/other"); !errors.Is(err, ErrNotCached) {
+			t.Fatalf("expected %v, got %v", ErrNotCached, err)
+		}
+		if len(client.requests) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(client.requests))
+		}
+	})
+
+	t.Run("body not matching the metadata is not served", func(t *testing.T) {
+		files := memFiles{}
+		client := &recordingClient{respond: func(*http.Request) *http.Response {
+			return htmlResponse(http.StatusOK, "album page", nil)
+		}}
+		c := newMemCachingClient(client, files, false)
+		get(c, "https://example.com/album")
+		// As if a writer replaced the body but not yet the metadata
+		files[c.entryPath("https://example.com/album")+".body"] = []byte("new album page")
+		offline := newMemCachingClient(client, files, true)
+		if _, err := get(offline, "https://example.com/album"); !errors.Is(err, ErrNotCached) {
+			t.Fatalf("expected %v, got %v", ErrNotCached, err)
+		}
+		if content, err := get(c, "https://example.com/album"); err != nil || content != "album page" {
+			t.Fatalf("expected album page, got %s (err: %v)", content, err)
+		}
+		if len(client.requests) != 2 {
+			t.Fatalf("expected 2 requests, got %d", len(client.requests))
+		}
+	})
+
+	t.Run("failing to write the cache", func(t *testing.T) {
+		client := &recordingClient{respond: func(*http.Request) *http.Response {
+			return htmlResponse(http.StatusOK, "album page", nil)
+		}}
+		c := newMemCachingClient(client, memFiles{}, false)
+		c.osWriteFile = func(string, []byte, os.FileMode) error { return os.ErrPermission }
+		if _, err := get(c, "https://example.com/album"); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("expected %v, got %v", os.ErrPermission, err)
+		}
+	})
+}
+
+func TestPruneHttpCache(t *testing.T) {
+	dir := t.TempDir()
+	client := &recordingClient{respond: func(*http.Request) *http.Response {
+		return htmlResponse(http.StatusOK, "page", nil)
+	}}
+	c := NewCachingClient(client, dir, time.Hour, false)
+	c.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	getUrl(context.Background(), c, "https://example.com/old")
+	c.now = time.Now
+	getUrl(context.Background(), c, "https://example.com/new")
+
+	if removed, err := PruneHttpCache(dir, time.Hour); err != nil || removed != 1 {
+		t.Fatalf("expected 1 removed, got %d (err: %v)", removed, err)
+	}
+	if removed, err := PruneHttpCache(dir, 0); err != nil || removed != 1 {
+		t.Fatalf("expected 1 removed, got %d (err: %v)", removed, err)
+	}
+	if removed, err := PruneHttpCache(dir+"/missing", 0); err != nil || removed != 0 {
+		t.Fatalf("expected 0 removed, got %d (err: %v)", removed, err)
+	}
+}
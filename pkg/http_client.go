@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	URL "net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type HttpClientOptions struct {
+	// Go's default if empty
+	UserAgent string
+	// http://, https://, socks5:// or socks5h:// URL. Environment variables such as
+	// HTTPS_PROXY are used if empty
+	Proxy string
+	// Zero means no timeout
+	ConnectTimeout time.Duration
+	// Maximum time to wait for response headers, and for each read of a response body.
+	// Zero means no timeout
+	ReadTimeout time.Duration
+	Headers     http.Header
+	Cookies     []*http.Cookie
+}
+
+type customHttpClient struct {
+	client      *http.Client
+	userAgent   string
+	headers     http.Header
+	readTimeout time.Duration
+}
+
+func NewHttpClient(opts HttpClientOptions) (HttpDoClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Proxy != "" {
+		proxyUrl, err := URL.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		switch proxyUrl.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyUrl.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+	if opts.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: opts.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = opts.ConnectTimeout
+	}
+	transport.ResponseHeaderTimeout = opts.ReadTimeout
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, cookie := range opts.Cookies {
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		u := &URL.URL{Scheme: scheme, Host: strings.TrimPrefix(cookie.Domain, "."), Path: cookie.Path}
+		if !strings.HasPrefix(cookie.Domain, ".") {
+			// Host-only cookie
+			c := *cookie
+			c.Domain = ""
+			cookie = &c
+		}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+
+	return &customHttpClient{
+		client:      &http.Client{Transport: transport, Jar: jar},
+		userAgent:   opts.UserAgent,
+		headers:     opts.Headers,
+		readTimeout: opts.ReadTimeout,
+	}, nil
+}
+
+func (c *customHttpClient) Do(req *http.Request) (*http.Response, error) {
+	// The caller's request may be sent again, so it is left as is
+	req = req.Clone(req.Context())
+	for k, vs := range c.headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil || c.readTimeout <= 0 {
+		return resp, err
+	}
+	resp.Body = newIdleTimeoutBody(resp.Body, c.readTimeout)
+	return resp, nil
+}
+
+// Closes the body if a read doesn't complete within the timeout. Only time spent in reads counts,
+// so that pauses of the reader such as throttling don't time out
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout  time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+func newIdleTimeoutBody(body io.ReadCloser, timeout time.Duration) *idleTimeoutBody {
+	return &idleTimeoutBody{ReadCloser: body, timeout: timeout}
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.timeout, func() {
+			b.timedOut.Store(true)
+			b.ReadCloser.Close()
+		})
+	} else {
+		b.timer.Reset(b.timeout)
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if b.timedOut.Load() {
+		return n, fmt.Errorf("read timed out after %s", b.timeout)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	return b.ReadCloser.Close()
+}
+
+// Parses cookies exported by browsers in the Netscape cookies.txt format
+func ReadNetscapeCookies(r io.Reader) ([]*http.Cookie, error) {
+	cookies := []*http.Cookie{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if after, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line = after
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("invalid cookie at line %d", lineNumber)
+		}
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		// Make sure subdomain cookies are marked as domain cookies
+		if strings.EqualFold(fields[1], "TRUE") && !strings.HasPrefix(cookie.Domain, ".") {
+			cookie.Domain = "." + cookie.Domain
+		}
+		if expires, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, cookie)
+	}
+	return cookies, scanner.Err()
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewHttpClient(t *testing.T) {
+	t.Run("happy path sends user agent, headers and cookies", func(t *testing.T) {
+		var received *http.Request
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		host := strings.TrimPrefix(server.URL, "http://")
+		hostname, _, _ := strings.Cut(host, ":")
+		client, err := NewHttpClient(HttpClientOptions{
+			UserAgent: "MyAgent/1.0",
+			Headers:   http.Header{"X-Extra": {"1"}},
+			Cookies: []*http.Cookie{
+				{Domain: hostname, Path: "/", Name: "session", Value: "abc"},
+				{Domain: "other.com", Path: "/", Name: "other", Value: "def"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := getUrl(context.Background(), client, server.URL+"/page")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body.Close()
+		if ua := received.Header.Get("User-Agent"); ua != "MyAgent/1.0" {
+			t.Fatalf("expected user agent, got %s", ua)
+		}
+		if h := received.Header.Get("X-Extra"); h != "1" {
+			t.Fatalf("expected extra header, got %s", h)
+		}
+		if c := received.Header.Get("Cookie"); c != "session=abc" {
+			t.Fatalf("expected cookie, got %s", c)
+		}
+
+		// Headers are added to a copy, so a retried request doesn't get them twice
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/page", nil)
+		for range 2 {
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+		}
+		if len(req.Header) != 0 || len(received.Header.Values("X-Extra")) != 1 {
+			t.Fatalf("expected the request to be left as is, got %v and %v", req.Header, received.Header.Values("X-Extra"))
+		}
+	})
+
+	t.Run("happy path goes through proxy", func(t *testing.T) {
+		var requested string
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = r.URL.String()
+			w.Write([]byte("proxied"))
+		}))
+		defer proxy.Close()
+
+		client, err := NewHttpClient(HttpClientOptions{Proxy: proxy.URL})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := getUrl(context.Background(), client, "http://example.invalid/album")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer body.Close()
+		if content, _ := io.ReadAll(body); string(content) != "proxied" || requested != "http://example.invalid/album" {
+			t.Fatalf("expected request through proxy, got %s", requested)
+		}
+	})
+
+	t.Run("unsupported proxy", func(t *testing.T) {
+		if _, err := NewHttpClient(HttpClientOptions{Proxy: "ftp://example.com"}); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+
+	t.Run("times out reading a stalled body", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		client, err := NewHttpClient(HttpClientOptions{ReadTimeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := getUrl(context.Background(), client, server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer body.Close()
+		if _, err := io.ReadAll(body); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("expected timeout, got %v", err)
+		}
+	})
+
+	t.Run("happy path pauses between reads don't time out", func(t *testing.T) {
+		body := newIdleTimeoutBody(io.NopCloser(strings.NewReader("content")), 20*time.Millisecond)
+		defer body.Close()
+		buf := make([]byte, 1)
+		for range 3 {
+			if _, err := body.Read(buf); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// As when throttled
+			time.Sleep(40 * time.Millisecond)
+		}
+	})
+}
+
+func TestReadNetscapeCookies(t *testing.T) {
+	input := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tTRUE\t1700000000\tsession\tabc\n" +
+		"#HttpOnly_example.com\tFALSE\t/path\tFALSE\t0\ttoken\tdef\r\n" +
+		"sub.example.com\tTRUE\t/\tFALSE\t0\tpref\tghi\n"
+	cookies, err := ReadNetscapeCookies(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []*http.Cookie{
+		{Domain: ".example.com", Path: "/", Secure: true, Name: "session", Value: "abc", Expires: time.Unix(1700000000, 0)},
+		{Domain: "example.com", Path: "/path", Name: "token", Value: "def", HttpOnly: true},
+		{Domain: ".sub.example.com", Path: "/", Name: "pref", Value: "ghi"},
+	}
+	if !reflect.DeepEqual(cookies, expected) {
+		t.Fatalf("expected %v, got %v", expected, cookies)
+	}
+
+	if _, err := ReadNetscapeCookies(strings.NewReader("example.com\tTRUE\t/\n")); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,311 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+var (
+	ErrJobNotFound  = errors.New("job not found")
+	ErrJobFinished  = errors.New("job already finished")
+	ErrJobDuplicate = errors.New("album already has a queued or running job")
+)
+
+// Album download submitted to a job queue
+type JobRequest struct {
+	Url string
+	// Tracks in the format of the -track flag. Default to all tracks
+	Tracks                []string `json:",omitzero"`
+	TrackFormatPreference []string `json:",omitzero"`
+	Formats               []string `json:",omitzero"`
+	NoDownloadImage       bool     `json:",omitzero"`
+	FixTags               bool     `json:",omitzero"`
+}
+
+type JobProgress struct {
+	// Estimated size in bytes of the files to download
+	TotalSize  int64 `json:",omitzero"`
+	Downloaded int64 `json:",omitzero"`
+	Files      int   `json:",omitzero"`
+}
+
+type Job struct {
+	Id       string
+	Request  JobRequest
+	State    string
+	Created  time.Time
+	Started  time.Time `json:",omitzero"`
+	Finished time.Time `json:",omitzero"`
+	// Album folder, once known
+	Folder   string `json:",omitzero"`
+	Progress JobProgress
+	Error    string `json:",omitzero"`
+}
+
+// Runs a job and returns the album folder. Progress may be reported as the download goes
+type JobRunner func(ctx context.Context, job Job, progress func(JobProgress)) (string, error)
+
+// Queue of jobs run by a fixed number of workers. Every change of state is saved, so that
+// jobs left queued or running are run again after a restart
+type JobQueue struct {
+	logger  *slog.Logger
+	save    func(jobs []*Job) error
+	run     JobRunner
+	workers int
+	now     func() time.Time
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []*Job
+	cancels map[string]context.CancelFunc
+}
+
+func newJobQueue(logger *slog.Logger, save func([]*Job) error, jobs []*Job, workers int, run JobRunner) *JobQueue {
+	q := &JobQueue{
+		logger:  logger,
+		save:    save,
+		run:     run,
+		workers: max(workers, 1),
+		now:     time.Now,
+		jobs:    jobs,
+		cancels: map[string]context.CancelFunc{},
+	}
+	q.cond = sync.NewCond(&q.mu)
+	for _, job := range jobs {
+		// Interrupted by a restart
+		if job.State == JobRunning {
+			job.State = JobQueued
+		}
+	}
+	return q
+}
+
+// $XDG_CONFIG_HOME/soundtrack-downloader/jobs.json or equivalent on other platforms
+func DefaultJobsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "soundtrack-downloader", "jobs.json")
+}
+
+func readJobs(r io.Reader) ([]*Job, error) {
+	var jobs []*Job
+	if err := json.NewDecoder(r).Decode(&jobs); err != nil {
+		return nil, fmt.Errorf("invalid jobs file: %w", err)
+	}
+	return jobs, nil
+}
+
+// Writes to a temporary file first so that a crash never leaves a truncated file
+func writeJobsFile(name string, jobs []*Job) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".tmp", data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+// Opens a job queue saved in a file. A missing file is an empty queue
+func OpenJobQueue(logger *slog.Logger, name string, workers int, run JobRunner) (*JobQueue, error) {
+	var jobs []*Job
+	f, err := os.Open(name)
+	if err == nil {
+		jobs, err = readJobs(f)
+		f.Close()
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	save := func(jobs []*Job) error {
+		return writeJobsFile(name, jobs)
+	}
+	return newJobQueue(logger, save, jobs, workers, run), nil
+}
+
+func (q *JobQueue) saveLocked() {
+	if err := q.save(q.jobs); err != nil {
+		q.logger.Warn("failed to save jobs: " + err.Error())
+	}
+}
+
+func (q *JobQueue) findLocked(id string) *Job {
+	i := slices.IndexFunc(q.jobs, func(job *Job) bool { return job.Id == id })
+	if i < 0 {
+		return nil
+	}
+	return q.jobs[i]
+}
+
+// Adds a job to the queue. An album can only have one unfinished job, as jobs of the same album
+// would write the same files
+func (q *JobQueue) Submit(req JobRequest) (Job, error) {
+	if strings.TrimSpace(req.Url) == "" {
+		return Job{}, fmt.Errorf("url is required")
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range q.jobs {
+		if (job.State == JobQueued || job.State == JobRunning) && sameAlbumUrl(job.Request.Url, req.Url) {
+			return *job, fmt.Errorf("%w: %s", ErrJobDuplicate, job.Id)
+		}
+	}
+	job := &Job{Id: strings.ToLower(rand.Text()[:10]), Request: req, State: JobQueued, Created: q.now()}
+	q.jobs = append(q.jobs, job)
+	q.saveLocked()
+	q.cond.Signal()
+	return *job, nil
+}
+
+// Returns all jobs, oldest first
+func (q *JobQueue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]Job, len(q.jobs))
+	for i, job := range q.jobs {
+		jobs[i] = *job
+	}
+	return jobs
+}
+
+func (q *JobQueue) Job(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.findLocked(id)
+	if job == nil {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Cancels a queued job at once, or a running job once its download stops
+func (q *JobQueue) Cancel(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job := q.findLocked(id)
+	switch {
+	case job == nil:
+		return Job{}, ErrJobNotFound
+	case job.State == JobQueued:
+		job.State = JobCanceled
+		job.Finished = q.now()
+		q.saveLocked()
+	case job.State == JobRunning:
+		q.cancels[id]()
+	default:
+		return *job, ErrJobFinished
+	}
+	return *job, nil
+}
+
+// Runs the workers until the context is done. Running jobs are stopped then and left queued
+func (q *JobQueue) Run(ctx context.Context) {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	})
+	defer stop()
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, jobCtx := q.next(ctx)
+				if job == nil {
+					return
+				}
+				q.runJob(ctx, jobCtx, job)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Waits for a queued job and marks it running, or returns nil once the context is done
+func (q *JobQueue) next(ctx context.Context) (*Job, context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ctx.Err() == nil {
+		i := slices.IndexFunc(q.jobs, func(job *Job) bool { return job.State == JobQueued })
+		if i < 0 {
+			q.cond.Wait()
+			continue
+		}
+		job := q.jobs[i]
+		jobCtx, cancel := context.WithCancel(ctx)
+		q.cancels[job.Id] = cancel
+		job.State = JobRunning
+		job.Started = q.now()
+		job.Error = ""
+		q.saveLocked()
+		return job, jobCtx
+	}
+	return nil, nil
+}
+
+func (q *JobQueue) runJob(ctx, jobCtx context.Context, job *Job) {
+	q.mu.Lock()
+	snapshot := *job
+	q.mu.Unlock()
+	q.logger.Info("job started", "job", job.Id, "url", snapshot.Request.Url)
+	folder, err := q.run(jobCtx, snapshot, func(p JobProgress) {
+		q.mu.Lock()
+		job.Progress = p
+		q.mu.Unlock()
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if folder != "" {
+		job.Folder = folder
+	}
+	switch {
+	case ctx.Err() != nil:
+		job.State = JobQueued
+		job.Started = time.Time{}
+		q.logger.Info("job stopped, to be resumed", "job", job.Id)
+	case jobCtx.Err() != nil:
+		job.State = JobCanceled
+		q.logger.Info("job canceled", "job", job.Id)
+	case err != nil:
+		job.State = JobFailed
+		job.Error = err.Error()
+		q.logger.Error("job failed: "+err.Error(), "job", job.Id)
+	default:
+		job.State = JobDone
+		q.logger.Info("job done", "job", job.Id, "folder", folder)
+	}
+	if job.State != JobQueued {
+		job.Finished = q.now()
+	}
+	q.cancels[job.Id]()
+	delete(q.cancels, job.Id)
+	q.saveLocked()
+}
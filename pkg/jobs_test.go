@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records the states of the jobs each time they are saved
+type jobsRecorder struct {
+	mu     sync.Mutex
+	states [][]string
+}
+
+func (r *jobsRecorder) save(jobs []*Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := []string{}
+	for _, job := range jobs {
+		states = append(states, job.State)
+	}
+	r.states = append(r.states, states)
+	return nil
+}
+
+func (r *jobsRecorder) last() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.states[len(r.states)-1]
+}
+
+func waitForJob(t *testing.T, q *JobQueue, id, state string) Job {
+	t.Helper()
+	for range 500 {
+		if job, _ := q.Job(id); job.State == state {
+			return job
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	job, _ := q.Job(id)
+	t.Fatalf("expected %v, got %v", state, job.State)
+	return job
+}
+
+func TestJobQueue(t *testing.T) {
+	t.Run("happy path", func(t *testing.T) {
+		recorder := &jobsRecorder{}
+		run := func(ctx context.Context, job Job, progress func(JobProgress)) (string, error) {
+			progress(JobProgress{TotalSize: 100, Downloaded: 100, Files: 2})
+			if job.Request.Url == "https://example.com/bad" {
+				return "", errors.New("unexpected response: 500")
+			}
+			return "My Album 1", nil
+		}
+		q := newJobQueue(slog.New(slog.DiscardHandler), recorder.save, nil, 2, run)
+		ok, err := q.Submit(JobRequest{Url: "https://example.com/my-album-1"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		bad, _ := q.Submit(JobRequest{Url: "https://example.com/bad"})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			q.Run(ctx)
+			close(done)
+		}()
+
+		job := waitForJob(t, q, ok.Id, JobDone)
+		if job.Folder != "My Album 1" || job.Progress.Downloaded != 100 || job.Finished.IsZero() {
+			t.Fatalf("unexpected job: %v", job)
+		}
+		job = waitForJob(t, q, bad.Id, JobFailed)
+		if job.Error != "unexpected response: 500" {
+			t.Fatalf("expected %v, got %v", "unexpected response: 500", job.Error)
+		}
+		cancel()
+		<-done
+		if states := recorder.last(); strings.Join(states, ",") != "done,failed" {
+			t.Fatalf("expected %v, got %v", "done,failed", states)
+		}
+	})
+
+	t.Run("url is required", func(t *testing.T) {
+		q := newJobQueue(slog.New(slog.DiscardHandler), (&jobsRecorder{}).save, nil, 1, nil)
+		if _, err := q.Submit(JobRequest{Url: " "}); err == nil {
+			t.Fatalf("expected error, got nil")
+		}
+	})
+
+	t.Run("one unfinished job per album", func(t *testing.T) {
+		q := newJobQueue(slog.New(slog.DiscardHandler), (&jobsRecorder{}).save, nil, 1, nil)
+		queued, _ := q.Submit(JobRequest{Url: "https://example.com/my-album-1"})
+		if job, err := q.Submit(JobRequest{Url: "https://example.com/my-album-1/"}); !errors.Is(err, ErrJobDuplicate) || job.Id != queued.Id {
+			t.Fatalf("expected %v for %v, got %v %v", ErrJobDuplicate, queued.Id, err, job.Id)
+		}
+		q.Cancel(queued.Id)
+		if _, err := q.Submit(JobRequest{Url: "https://example.com/my-album-1"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		started := make(chan struct{})
+		run := func(ctx context.Context, job Job, progress func(JobProgress)) (string, error) {
+			close(started)
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		q := newJobQueue(slog.New(slog.DiscardHandler), (&jobsRecorder{}).save, nil, 1, run)
+		running, _ := q.Submit(JobRequest{Url: "https://example.com/my-album-1"})
+		queued, _ := q.Submit(JobRequest{Url: "https://example.com/my-album-2"})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go q.Run(ctx)
+		<-started
+
+		if job, err := q.Cancel(queued.Id); err != nil || job.State != JobCanceled {
+			t.Fatalf("expected %v, got %v %v", JobCanceled, job.State, err)
+		}
+		if _, err := q.Cancel(running.Id); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		waitForJob(t, q, running.Id, JobCanceled)
+		if _, err := q.Cancel(running.Id); !errors.Is(err, ErrJobFinished) {
+			t.Fatalf("expected %v, got %v", ErrJobFinished, err)
+		}
+		if _, err := q.Cancel("unknown"); !errors.Is(err, ErrJobNotFound) {
+			t.Fatalf("expected %v, got %v", ErrJobNotFound, err)
+		}
+	})
+
+	t.Run("stopped jobs are resumed after restart", func(t *testing.T) {
+		started := make(chan struct{})
+		run := func(ctx context.Context, job Job, progress func(JobProgress)) (string, error) {
+			close(started)
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		recorder := &jobsRecorder{}
+		q := newJobQueue(slog.New(slog.DiscardHandler), recorder.save, nil, 1, run)
+		q.Submit(JobRequest{Url: "https://example.com/my-album-1"})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			q.Run(ctx)
+			close(done)
+		}()
+		<-started
+		cancel()
+		<-done
+		if states := recorder.last(); len(states) != 1 || states[0] != JobQueued {
+			t.Fatalf("expected %v, got %v", []string{JobQueued}, states)
+		}
+
+		// A crash leaves the job running in the file
+		jobs, err := readJobs(strings.NewReader(`[{"Id": "a1", "Request": {"Url": "https://example.com/my-album-1"}, "State": "running"}]`))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		q = newJobQueue(slog.New(slog.DiscardHandler), recorder.save, jobs, 1, run)
+		if job, _ := q.Job("a1"); job.State != JobQueued {
+			t.Fatalf("expected %v, got %v", JobQueued, job.State)
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.senan.xyz/taglib"
+)
+
+type LintIssue struct {
+	// Empty for issues concerning the whole album
+	File    string `json:",omitzero"`
+	Kind    string
+	Message string
+}
+
+const (
+	LintUnreadable      = "unreadable"
+	LintInconsistentTag = "inconsistent-tag"
+	LintEmptyTitle      = "empty-title"
+	LintNoTrackNumber   = "no-track-number"
+	LintDuplicateTrack  = "duplicate-track"
+	LintMissingTrack    = "missing-track"
+	LintTagMismatch     = "tag-mismatch"
+)
+
+// Tags that should be the same in all files of an album
+var albumWideTags = []string{taglib.Album, taglib.AlbumArtist, taglib.Date}
+
+// "01", "1" and "1/12" are the same number
+func normalizeNumberTag(v string) string {
+	v, _, _ = strings.Cut(strings.TrimSpace(v), "/")
+	if v = strings.TrimLeft(v, "0"); v == "" {
+		return "0"
+	}
+	return v
+}
+
+// Missing disc number is the first disc
+func normalizeDiscTag(v string) string {
+	if strings.TrimSpace(v) == "" {
+		return "1"
+	}
+	return normalizeNumberTag(v)
+}
+
+func firstTag(tags map[string][]string, key string) string {
+	if v := tags[key]; len(v) != 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func lintAlbum(
+	osOpen func(name string) (io.ReadCloser, error),
+	osReadDir func(name string) ([]os.DirEntry, error),
+	taglibReadTags func(path string) (map[string][]string, error),
+	workpath string,
+) ([]LintIssue, error) {
+	var albumInfo *AlbumInfo
+	if f, err := osOpen(filepath.Join(workpath, "info.json")); err == nil {
+		defer f.Close()
+		albumInfo = &AlbumInfo{}
+		if err := json.NewDecoder(f).Decode(albumInfo); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	dirEntries, err := osReadDir(workpath)
+	if err != nil {
+		return nil, err
+	}
+
+	issues := []LintIssue{}
+	fileTags := map[string]map[string][]string{}
+	fileNames := []string{}
+	for _, dirEntry := range dirEntries {
+		upperExt := strings.ToUpper(strings.TrimPrefix(filepath.Ext(dirEntry.Name()), "."))
+		if dirEntry.IsDir() || !musicExts.Contains(upperExt) {
+			continue
+		}
+		tags, err := taglibReadTags(filepath.Join(workpath, dirEntry.Name()))
+		if err != nil {
+			issues = append(issues, LintIssue{dirEntry.Name(), LintUnreadable, err.Error()})
+			continue
+		}
+		fileTags[dirEntry.Name()] = tags
+		fileNames = append(fileNames, dirEntry.Name())
+	}
+
+	for _, key := range albumWideTags {
+		values := []string{}
+		for _, name := range fileNames {
+			if v := strings.Join(fileTags[name][key], multiValueJoiner); !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+		if len(values) > 1 {
+			issues = append(issues, LintIssue{"", LintInconsistentTag, fmt.Sprintf("%s differs between files: %q", key, values)})
+		}
+	}
+
+	tracks := map[TrackNumberKey][]string{}
+	for _, name := range fileNames {
+		tags := fileTags[name]
+		if strings.TrimSpace(firstTag(tags, taglib.Title)) == "" {
+			issues = append(issues, LintIssue{name, LintEmptyTitle, "title is empty"})
+		}
+		track := firstTag(tags, taglib.TrackNumber)
+		if strings.TrimSpace(track) == "" {
+			issues = append(issues, LintIssue{name, LintNoTrackNumber, "track number is missing"})
+			continue
+		}
+		key := TrackNumberKey{normalizeDiscTag(firstTag(tags, taglib.DiscNumber)), normalizeNumberTag(track)}
+		tracks[key] = append(tracks[key], name)
+	}
+	for _, key := range slices.SortedFunc(maps.Keys(tracks), compareTrackNumberKeys) {
+		if names := tracks[key]; len(names) > 1 {
+			issues = append(issues, LintIssue{"", LintDuplicateTrack, fmt.Sprintf("disc %s track %s is shared by %q", key.DiscNumber, key.TrackNumber, names)})
+		}
+	}
+
+	if albumInfo == nil {
+		issues = append(issues, trackNumberGaps(tracks)...)
+	} else {
+		for i := range albumInfo.Tracks {
+			t := &albumInfo.Tracks[i]
+			if t.TrackNumber == "" {
+				continue
+			}
+			key := TrackNumberKey{normalizeDiscTag(t.DiscNumber), normalizeNumberTag(t.TrackNumber)}
+			if _, ok := tracks[key]; !ok {
+				issues = append(issues, LintIssue{"", LintMissingTrack, fmt.Sprintf("disc %s track %s (%s) from info.json has no file", key.DiscNumber, key.TrackNumber, t.Name)})
+			}
+		}
+
+		expectedFileTags := AlbumInfoToFileTags(albumInfo)
+		for _, name := range fileNames {
+			expected, ok := expectedFileTags[name]
+			if !ok {
+				continue
+			}
+			tags := fileTags[name]
+			for _, key := range slices.Sorted(maps.Keys(expected)) {
+				want, got := firstTag(expected, key), firstTag(tags, key)
+				if key == taglib.TrackNumber || key == taglib.DiscNumber {
+					want, got = normalizeNumberTag(want), normalizeNumberTag(got)
+				}
+				if want != got {
+					issues = append(issues, LintIssue{name, LintTagMismatch, fmt.Sprintf("%s is %q but info.json has %q", key, firstTag(tags, key), firstTag(expected, key))})
+				}
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// Reports tag problems of music files in an album folder, cross-checked with its info.json if present
+func LintAlbum(workpath string) ([]LintIssue, error) {
+	osOpen := func(name string) (io.ReadCloser, error) {
+		return os.Open(name) // covariance
+	}
+	return lintAlbum(osOpen, os.ReadDir, taglib.ReadTags, workpath)
+}
+
+// Without info.json, track numbers below the highest one found on each disc that have no file
+func trackNumberGaps(tracks map[TrackNumberKey][]string) []LintIssue {
+	discTracks := map[string][]int{}
+	for key := range tracks {
+		if n, err := strconv.Atoi(key.TrackNumber); err == nil && n > 0 {
+			discTracks[key.DiscNumber] = append(discTracks[key.DiscNumber], n)
+		}
+	}
+	issues := []LintIssue{}
+	for _, disc := range slices.SortedFunc(maps.Keys(discTracks), func(a, b string) int {
+		return compareTrackNumberKeys(TrackNumberKey{DiscNumber: a}, TrackNumberKey{DiscNumber: b})
+	}) {
+		numbers := discTracks[disc]
+		for n := 1; n < slices.Max(numbers); n++ {
+			if !slices.Contains(numbers, n) {
+				issues = append(issues, LintIssue{"", LintMissingTrack, fmt.Sprintf("disc %s track %d is missing", disc, n)})
+			}
+		}
+	}
+	return issues
+}
+
+func compareTrackNumberKeys(a, b TrackNumberKey) int {
+	compareNumbers := func(x, y string) int {
+		if len(x) != len(y) {
+			return len(x) - len(y)
+		}
+		return strings.Compare(x, y)
+	}
+	if c := compareNumbers(a.DiscNumber, b.DiscNumber); c != 0 {
+		return c
+	}
+	return compareNumbers(a.TrackNumber, b.TrackNumber)
+}
+
+func WriteLintIssuesText(w io.Writer, issues []LintIssue) error {
+	for _, issue := range issues {
+		file := issue.File
+		if file == "" {
+			file = "(album)"
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s: %s\n", file, issue.Kind, issue.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func WriteLintIssuesJSON(w io.Writer, issues []LintIssue) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(issues)
+}
@@ -0,0 +1,148 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.senan.xyz/taglib"
+)
+
+func TestLintAlbum(t *testing.T) {
+	mkOsReadDir := func(name string) ([]os.DirEntry, error) {
+		return []os.DirEntry{
+			&mockDirEntry{name: "1-01. Song1.flac"},
+			&mockDirEntry{name: "1-02. Song2.flac"},
+			&mockDirEntry{name: "1-02. Song2 (copy).flac"},
+			&mockDirEntry{name: "broken.mp3"},
+			&mockDirEntry{name: "info.json"},
+		}, nil
+	}
+	existingTags := map[string]map[string][]string{
+		"My Album/1-01. Song1.flac": {
+			taglib.Album:       {"My Album"},
+			taglib.Title:       {"Song 1"},
+			taglib.DiscNumber:  {"1"},
+			taglib.TrackNumber: {"01/3"},
+		},
+		"My Album/1-02. Song2.flac": {
+			taglib.Album:       {"My Album"},
+			taglib.Title:       {"Song Two"},
+			taglib.TrackNumber: {"2"},
+		},
+		"My Album/1-02. Song2 (copy).flac": {
+			taglib.Album:       {"Other Album"},
+			taglib.DiscNumber:  {"01"},
+			taglib.TrackNumber: {"02"},
+		},
+	}
+	mkReadTags := func(path string) (map[string][]string, error) {
+		if tags, ok := existingTags[path]; ok {
+			return tags, nil
+		}
+		return nil, fmt.Errorf("invalid file")
+	}
+
+	t.Run("happy path without info.json", func(t *testing.T) {
+		mkOpen := func(name string) (io.ReadCloser, error) { return nil, os.ErrNotExist }
+		issues, err := lintAlbum(mkOpen, mkOsReadDir, mkReadTags, "My Album")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []LintIssue{
+			{"broken.mp3", LintUnreadable, "invalid file"},
+			{"", LintInconsistentTag, `ALBUM differs between files: ["My Album" "Other Album"]`},
+			{"1-02. Song2 (copy).flac", LintEmptyTitle, "title is empty"},
+			{"", LintDuplicateTrack, `disc 1 track 2 is shared by ["1-02. Song2.flac" "1-02. Song2 (copy).flac"]`},
+		}
+		if !reflect.DeepEqual(issues, expected) {
+			t.Fatalf("expected %v, got %v", expected, issues)
+		}
+	})
+
+	t.Run("happy path finds gaps in track numbers without info.json", func(t *testing.T) {
+		mkOpen := func(name string) (io.ReadCloser, error) { return nil, os.ErrNotExist }
+		mkOsReadDir := func(name string) ([]os.DirEntry, error) {
+			return []os.DirEntry{
+				&mockDirEntry{name: "1-02. Song2.flac"},
+				&mockDirEntry{name: "1-05. Song5.flac"},
+				&mockDirEntry{name: "2-01. Song1.flac"},
+			}, nil
+		}
+		mkReadTags := func(path string) (map[string][]string, error) {
+			disc, track, _ := strings.Cut(strings.TrimPrefix(path, "My Album/")[:4], "-")
+			return map[string][]string{taglib.Album: {"My Album"}, taglib.Title: {path}, taglib.DiscNumber: {disc}, taglib.TrackNumber: {track}}, nil
+		}
+		issues, err := lintAlbum(mkOpen, mkOsReadDir, mkReadTags, "My Album")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []LintIssue{
+			{"", LintMissingTrack, "disc 1 track 1 is missing"},
+			{"", LintMissingTrack, "disc 1 track 3 is missing"},
+			{"", LintMissingTrack, "disc 1 track 4 is missing"},
+		}
+		if !reflect.DeepEqual(issues, expected) {
+			t.Fatalf("expected %v, got %v", expected, issues)
+		}
+	})
+
+	t.Run("happy path compares against info.json", func(t *testing.T) {
+		mkOpen := func(name string) (io.ReadCloser, error) {
+			if name != "My Album/info.json" {
+				t.Fatalf("expected to open info.json, got %s", name)
+			}
+			info := AlbumInfo{
+				Name: "My Album",
+				Tracks: []TrackInfo{
+					{Name: "Song 1", DiscNumber: "1", TrackNumber: "1", SongUrl: map[string]string{"FLAC": "https://example.com/1-01.%20Song1.flac"}},
+					{Name: "Song 2", DiscNumber: "1", TrackNumber: "2", SongUrl: map[string]string{"FLAC": "https://example.com/1-02.%20Song2.flac"}},
+					{Name: "Song 3", DiscNumber: "1", TrackNumber: "3", SongUrl: map[string]string{"FLAC": "https://example.com/1-03.%20Song3.flac"}},
+				},
+			}
+			buffer := new(bytes.Buffer)
+			_ = json.NewEncoder(buffer).Encode(info)
+			return io.NopCloser(buffer), nil
+		}
+		issues, err := lintAlbum(mkOpen, mkOsReadDir, mkReadTags, "My Album")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []LintIssue{
+			{"", LintMissingTrack, "disc 1 track 3 (Song 3) from info.json has no file"},
+			{"1-02. Song2.flac", LintTagMismatch, `DISCNUMBER is "" but info.json has "1"`},
+			{"1-02. Song2.flac", LintTagMismatch, `TITLE is "Song Two" but info.json has "Song 2"`},
+		}
+		if !reflect.DeepEqual(issues[4:], expected) {
+			t.Fatalf("expected %v, got %v", expected, issues[4:])
+		}
+	})
+
+	t.Run("invalid info.json", func(t *testing.T) {
+		mkOpen := func(name string) (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("{")), nil }
+		if _, err := lintAlbum(mkOpen, mkOsReadDir, mkReadTags, "My Album"); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+func TestWriteLintIssuesText(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	issues := []LintIssue{
+		{"", LintMissingTrack, "disc 1 track 3 (Song 3) from info.json has no file"},
+		{"broken.mp3", LintUnreadable, "invalid file"},
+	}
+	if err := WriteLintIssuesText(buffer, issues); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "(album): missing-track: disc 1 track 3 (Song 3) from info.json has no file\n" +
+		"broken.mp3: unreadable: invalid file\n"
+	if buffer.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buffer.String())
+	}
+}
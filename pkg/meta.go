@@ -2,14 +2,17 @@ package pkg
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"go.senan.xyz/taglib"
@@ -46,9 +49,11 @@ func fixTags(
 	osReadDir func(name string) ([]os.DirEntry, error),
 	taglibReadTags func(path string) (map[string][]string, error),
 	taglibWriteTags func(path string, tags map[string][]string, opts taglib.WriteOption) error,
-	tags map[string]string,
-	fileSpecificTags map[string]map[string]string,
+	tags map[string][]string,
+	fileSpecificTags map[string]map[string][]string,
 	overwrites TagKeySet,
+	cleanup TagCleanup,
+	joinFormats InsStringKeySet,
 	workpath string,
 	inferNames bool,
 	readAlbumInfo bool,
@@ -59,11 +64,18 @@ func fixTags(
 	// > inferred file-specific tags
 	// > albumInfo file-specific tags
 	// > albumInfo tags
-	var albumInfoTags map[string]string
-	var albumInfoFileSpecificTags map[string]map[string]string
+	var albumInfoTags map[string][]string
+	var albumInfoFileSpecificTags map[string]map[string][]string
 	if readAlbumInfo {
 		var albumInfo AlbumInfo
 		f, err := osOpen(filepath.Join(workpath, "info.json"))
+		inParent := false
+		if errors.Is(err, os.ErrNotExist) {
+			// A format subfolder has the info.json in its parent
+			if parent, parentErr := osOpen(filepath.Join(workpath, "..", "info.json")); parentErr == nil {
+				f, err, inParent = parent, nil, true
+			}
+		}
 		if err != nil {
 			return err
 		}
@@ -71,6 +83,9 @@ func fixTags(
 		if err := json.NewDecoder(f).Decode(&albumInfo); err != nil {
 			return err
 		}
+		if inParent && !slices.Contains(slices.Collect(maps.Values(albumInfo.FormatFolders)), filepath.Base(workpath)) {
+			return fmt.Errorf("no info.json in %s", workpath)
+		}
 		albumInfoTags = AlbumInfoToTags(&albumInfo)
 		albumInfoFileSpecificTags = AlbumInfoToFileTags(&albumInfo)
 	}
@@ -87,9 +102,9 @@ func fixTags(
 		}
 
 		actualTags := map[string][]string{}
-		stackTags := func(src map[string]string) {
+		stackTags := func(src map[string][]string) {
 			for k, v := range src {
-				actualTags[k] = []string{v}
+				actualTags[k] = v
 			}
 		}
 
@@ -98,7 +113,9 @@ func fixTags(
 			stackTags(fileTags)
 		}
 		if inferNames {
-			stackTags(inferTagsFromFileName(dirEntry.Name()))
+			for k, v := range inferTagsFromFileName(dirEntry.Name()) {
+				actualTags[k] = []string{v}
+			}
 		}
 		stackTags(tags)
 		if fileTags, ok := fileSpecificTags[dirEntry.Name()]; ok {
@@ -118,6 +135,15 @@ func fixTags(
 				delete(actualTags, k)
 			}
 		}
+		// Removals and cleanups are explicit, so they are not subject to overwrites
+		cleanup.apply(existingTags, actualTags)
+		if joinFormats.Contains(upperExt) {
+			for k, v := range actualTags {
+				if len(v) > 1 {
+					actualTags[k] = []string{strings.Join(v, multiValueJoiner)}
+				}
+			}
+		}
 
 		if len(actualTags) == 0 {
 			logger.Info(fmt.Sprintf("nothing to do for %s", dirEntry.Name()))
@@ -126,7 +152,11 @@ func fixTags(
 
 		pairs := make([]string, 0, len(actualTags))
 		for k, v := range actualTags {
-			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", k, v[0]))
+			if len(v) == 0 {
+				pairs = append(pairs, fmt.Sprintf("%s removed", k))
+				continue
+			}
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", k, strings.Join(v, "\", \"")))
 		}
 		catPairs := strings.Join(pairs, ", ")
 		if noFix {
@@ -145,9 +175,11 @@ func fixTags(
 
 func FixTags(
 	logger *slog.Logger,
-	tags map[string]string,
-	fileSpecificTags map[string]map[string]string,
+	tags map[string][]string,
+	fileSpecificTags map[string]map[string][]string,
 	overwrites TagKeySet,
+	cleanup TagCleanup,
+	joinFormats InsStringKeySet,
 	workpath string,
 	inferNames bool,
 	readAlbumInfo bool,
@@ -156,57 +188,62 @@ func FixTags(
 	osOpen := func(name string) (io.ReadCloser, error) {
 		return os.Open(name) // covariance
 	}
-	return fixTags(logger, osOpen, os.ReadDir, taglib.ReadTags, taglib.WriteTags, tags, fileSpecificTags, overwrites, workpath, inferNames, readAlbumInfo, noFix)
+	return fixTags(logger, osOpen, os.ReadDir, taglib.ReadTags, taglib.WriteTags, tags, fileSpecificTags, overwrites, cleanup, joinFormats, workpath, inferNames, readAlbumInfo, noFix)
 }
 
 type TagKeySet = InsStringKeySet
 
+// Tags that commonly hold multiple values
+var MultiValueTags = TagKeySet{taglib.Artist: {}, taglib.AlbumArtist: {}, taglib.Genre: {}, taglib.Label: {}, taglib.Composer: {}}
+
+// Used where multiple values have to be represented by a single string
+const multiValueJoiner = "; "
+
 var (
 	NoOverWriteTags  = TagKeySet{}
 	OverwriteAllTags = TagKeySet{"*": {}}
 )
 
-func AlbumInfoToTags(albumInfo *AlbumInfo) map[string]string {
-	joiner := "; "
-	tags := map[string]string{}
+func AlbumInfoToTags(albumInfo *AlbumInfo) map[string][]string {
+	tags := map[string][]string{}
 	if albumInfo.Name != "" {
-		tags[taglib.Album] = albumInfo.Name
+		tags[taglib.Album] = []string{albumInfo.Name}
 	}
 	if len(albumInfo.Year) != 0 {
-		tags[taglib.Date] = strings.Join(albumInfo.Year, joiner)
+		tags[taglib.Date] = []string{strings.Join(albumInfo.Year, multiValueJoiner)}
 	}
 	if len(albumInfo.Developer) != 0 {
-		tags[taglib.AlbumArtist] = strings.Join(albumInfo.Developer, joiner)
+		tags[taglib.AlbumArtist] = albumInfo.Developer
 	}
 	if len(albumInfo.Publisher) != 0 {
-		tags[taglib.Label] = strings.Join(albumInfo.Publisher, joiner)
+		tags[taglib.Label] = albumInfo.Publisher
 		if len(albumInfo.Developer) == 0 {
-			tags[taglib.AlbumArtist] = strings.Join(albumInfo.Publisher, joiner)
+			tags[taglib.AlbumArtist] = albumInfo.Publisher
 		}
 	}
 	if len(albumInfo.CatalogNumber) != 0 {
-		tags[taglib.CatalogNumber] = strings.Join(albumInfo.CatalogNumber, joiner)
+		tags[taglib.CatalogNumber] = []string{strings.Join(albumInfo.CatalogNumber, multiValueJoiner)}
 	}
 	if len(albumInfo.AlbumType) != 0 {
-		tags[taglib.Genre] = strings.Join(albumInfo.AlbumType, joiner)
+		tags[taglib.Genre] = albumInfo.AlbumType
 	}
 	return tags
 }
 
 // Maps file names to tags
-func AlbumInfoToFileTags(albumInfo *AlbumInfo) map[string]map[string]string {
-	res := map[string]map[string]string{}
+func AlbumInfoToFileTags(albumInfo *AlbumInfo) map[string]map[string][]string {
+	res := map[string]map[string][]string{}
 	for i := range albumInfo.Tracks {
 		t := &albumInfo.Tracks[i]
-		tags := map[string]string{}
+		tags := map[string][]string{}
 		if t.Name != "" {
-			tags[taglib.Title] = t.Name
+			tags[taglib.Title] = []string{t.Name}
 		}
 		if t.DiscNumber != "" {
-			tags[taglib.DiscNumber] = t.DiscNumber
+			tags[taglib.DiscNumber] = []string{t.DiscNumber}
 		}
 		if t.TrackNumber != "" {
-			tags[taglib.TrackNumber] = t.TrackNumber
+			tags[taglib.TrackNumber] = []string{t.TrackNumber}
 		}
 		// Get file name
 		for _, link := range t.SongUrl {
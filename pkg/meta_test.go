@@ -63,9 +63,9 @@ func (m *mockDirEntry) Type() os.FileMode          { return 0 }
 func TestFixTags(t *testing.T) {
 	logger := slog.New(slog.DiscardHandler)
 	t.Run("happy path merges provided tags, inferred triplets, album info and existing tags in order", func(t *testing.T) {
-		providedtags := map[string]string{
-			taglib.Artist:      "MyArtist",
-			taglib.AlbumArtist: "MyAlbumArtist",
+		providedtags := map[string][]string{
+			taglib.Artist:      {"MyArtist"},
+			taglib.AlbumArtist: {"MyAlbumArtist"},
 		}
 		mkOpen := func(name string) (io.ReadCloser, error) {
 			if name != "My Album/info.json" {
@@ -111,7 +111,7 @@ func TestFixTags(t *testing.T) {
 			records[path] = tags
 			return nil
 		}
-		err := fixTags(logger, mkOpen, mkOsReadDir, mkReadTags, mkWriteTags, providedtags, nil, NoOverWriteTags, "My Album", true, true, false)
+		err := fixTags(logger, mkOpen, mkOsReadDir, mkReadTags, mkWriteTags, providedtags, nil, NoOverWriteTags, TagCleanup{}, nil, "My Album", true, true, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err.Error())
 		}
@@ -139,9 +139,9 @@ func TestFixTags(t *testing.T) {
 	})
 
 	t.Run("happy path merges provided tags, inferred doublets existing tags in order", func(t *testing.T) {
-		providedtags := map[string]string{
-			taglib.Artist:      "MyArtist",
-			taglib.AlbumArtist: "MyAlbumArtist",
+		providedtags := map[string][]string{
+			taglib.Artist:      {"MyArtist"},
+			taglib.AlbumArtist: {"MyAlbumArtist"},
 		}
 		mkOsReadDir := func(name string) ([]os.DirEntry, error) {
 			if name != "My Album" {
@@ -172,7 +172,7 @@ func TestFixTags(t *testing.T) {
 			records[path] = tags
 			return nil
 		}
-		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, providedtags, nil, NoOverWriteTags, "My Album", true, false, false)
+		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, providedtags, nil, NoOverWriteTags, TagCleanup{}, nil, "My Album", true, false, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err.Error())
 		}
@@ -226,7 +226,7 @@ func TestFixTags(t *testing.T) {
 			records[path] = tags
 			return nil
 		}
-		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, nil, nil, OverwriteAllTags, "My Album", true, false, false)
+		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, nil, nil, OverwriteAllTags, TagCleanup{}, nil, "My Album", true, false, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err.Error())
 		}
@@ -244,17 +244,17 @@ func TestFixTags(t *testing.T) {
 	})
 
 	t.Run("happy path merges provided file tags, provided tags, album info, and album info file tags in order", func(t *testing.T) {
-		providedFileTags := map[string]map[string]string{
+		providedFileTags := map[string]map[string][]string{
 			"1-01. Song1.flac": {
-				taglib.Artist:      "Solo",
-				taglib.Title:       "Song 1 (Fixed)",
-				taglib.DiscNumber:  "1",
-				taglib.TrackNumber: "01",
+				taglib.Artist:      {"Solo"},
+				taglib.Title:       {"Song 1 (Fixed)"},
+				taglib.DiscNumber:  {"1"},
+				taglib.TrackNumber: {"01"},
 			},
 		}
-		providedTags := map[string]string{
-			taglib.Date:        "2022",
-			taglib.AlbumArtist: "My Album Artist",
+		providedTags := map[string][]string{
+			taglib.Date:        {"2022"},
+			taglib.AlbumArtist: {"My Album Artist"},
 		}
 		mkOpen := func(name string) (io.ReadCloser, error) {
 			if name != "My Album/info.json" {
@@ -299,7 +299,7 @@ func TestFixTags(t *testing.T) {
 			records[path] = tags
 			return nil
 		}
-		err := fixTags(logger, mkOpen, mkOsReadDir, mkReadTags, mkWriteTags, providedTags, providedFileTags, OverwriteAllTags, "My Album", false, true, false)
+		err := fixTags(logger, mkOpen, mkOsReadDir, mkReadTags, mkWriteTags, providedTags, providedFileTags, OverwriteAllTags, TagCleanup{}, nil, "My Album", false, true, false)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err.Error())
 		}
@@ -327,10 +327,126 @@ func TestFixTags(t *testing.T) {
 		}
 	})
 
+	t.Run("happy path reads album info of the parent folder in a format subfolder", func(t *testing.T) {
+		mkOpen := func(name string) (io.ReadCloser, error) {
+			if name != "My Album/info.json" {
+				return nil, os.ErrNotExist
+			}
+			info := AlbumInfo{
+				Name:          "My Album",
+				Tracks:        []TrackInfo{{SongUrl: map[string]string{"MP3": "https://example.com/01.%20Song1.mp3"}, Name: "Song 1", TrackNumber: "1"}},
+				FormatFolders: map[string]string{"FLAC": "FLAC", "MP3": "MP3"},
+			}
+			buffer := new(bytes.Buffer)
+			_ = json.NewEncoder(buffer).Encode(info)
+			return io.NopCloser(buffer), nil
+		}
+		mkOsReadDir := func(name string) ([]os.DirEntry, error) {
+			return []os.DirEntry{&mockDirEntry{name: "01. Song1.mp3"}}, nil
+		}
+		mkReadTags := func(path string) (map[string][]string, error) { return nil, nil }
+		records := map[string]map[string][]string{}
+		mkWriteTags := func(path string, tags map[string][]string, opts taglib.WriteOption) error {
+			records[path] = tags
+			return nil
+		}
+		err := fixTags(logger, mkOpen, mkOsReadDir, mkReadTags, mkWriteTags, nil, nil, OverwriteAllTags, TagCleanup{}, nil, "My Album/MP3", false, true, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		expectedRecords := map[string]map[string][]string{
+			"My Album/MP3/01. Song1.mp3": {
+				taglib.Album:       {"My Album"},
+				taglib.Title:       {"Song 1"},
+				taglib.TrackNumber: {"1"},
+			},
+		}
+		if !reflect.DeepEqual(records, expectedRecords) {
+			t.Fatalf("expected records to be %v, got %v", expectedRecords, records)
+		}
+
+		err = fixTags(logger, mkOpen, mkOsReadDir, mkReadTags, mkWriteTags, nil, nil, OverwriteAllTags, TagCleanup{}, nil, "My Album/Other", false, true, false)
+		if err == nil {
+			t.Fatalf("expected error for a folder that is not a format subfolder")
+		}
+	})
+
+	t.Run("happy path removes and cleans existing tags regardless of overwrites", func(t *testing.T) {
+		mkOsReadDir := func(name string) ([]os.DirEntry, error) {
+			return []os.DirEntry{&mockDirEntry{name: "01. Song1.mp3"}, &mockDirEntry{name: "02. Song2.mp3"}}, nil
+		}
+		existingTags := map[string]map[string][]string{
+			"My Album/01. Song1.mp3": {
+				taglib.Title:     {"Song1"},
+				taglib.EncodedBy: {"LAME"},
+				taglib.Comment:   {"Ripped by someone"},
+			},
+			"My Album/02. Song2.mp3": {
+				taglib.Title: {"Song2"},
+			},
+		}
+		mkReadTags := func(path string) (map[string][]string, error) {
+			return existingTags[path], nil
+		}
+		records := map[string]map[string][]string{}
+		mkWriteTags := func(path string, tags map[string][]string, opts taglib.WriteOption) error {
+			records[path] = tags
+			return nil
+		}
+		rule, _ := ParseTagValueRule("COMMENT:s/ripped by.*//i")
+		cleanup := TagCleanup{Remove: TagKeySet{"ENCODEDBY": {}}, Rules: []TagValueRule{rule}}
+		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, nil, nil, NoOverWriteTags, cleanup, nil, "My Album", false, false, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		expectedRecords := map[string]map[string][]string{
+			"My Album/01. Song1.mp3": {
+				taglib.EncodedBy: {},
+				taglib.Comment:   {},
+			},
+		}
+		if !reflect.DeepEqual(records, expectedRecords) {
+			t.Fatalf("expected records to be %v, got %v", expectedRecords, records)
+		}
+	})
+
+	t.Run("happy path writes multiple values and joins them for some formats", func(t *testing.T) {
+		providedTags := map[string][]string{
+			taglib.Artist: {"A", "B"},
+			taglib.Title:  {"Song"},
+		}
+		mkOsReadDir := func(name string) ([]os.DirEntry, error) {
+			return []os.DirEntry{&mockDirEntry{name: "Song.flac"}, &mockDirEntry{name: "Song.mp3"}}, nil
+		}
+		mkReadTags := func(path string) (map[string][]string, error) { return nil, nil }
+		records := map[string]map[string][]string{}
+		mkWriteTags := func(path string, tags map[string][]string, opts taglib.WriteOption) error {
+			records[path] = tags
+			return nil
+		}
+		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, providedTags, nil, NoOverWriteTags, TagCleanup{}, InsStringKeySet{"MP3": {}}, "My Album", false, false, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		expectedRecords := map[string]map[string][]string{
+			"My Album/Song.flac": {
+				taglib.Artist: {"A", "B"},
+				taglib.Title:  {"Song"},
+			},
+			"My Album/Song.mp3": {
+				taglib.Artist: {"A; B"},
+				taglib.Title:  {"Song"},
+			},
+		}
+		if !reflect.DeepEqual(records, expectedRecords) {
+			t.Fatalf("expected records to be %v, got %v", expectedRecords, records)
+		}
+	})
+
 	t.Run("happy path only prints the proposed changes when noFix is true", func(t *testing.T) {
-		providedtags := map[string]string{
-			taglib.Artist:      "MyArtist",
-			taglib.AlbumArtist: "MyAlbumArtist",
+		providedtags := map[string][]string{
+			taglib.Artist:      {"MyArtist"},
+			taglib.AlbumArtist: {"MyAlbumArtist"},
 		}
 		mkOsReadDir := func(name string) ([]os.DirEntry, error) {
 			if name != "My Album" {
@@ -345,7 +461,7 @@ func TestFixTags(t *testing.T) {
 			t.Fatalf("unexpected write to %s", path)
 			return nil
 		}
-		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, providedtags, nil, OverwriteAllTags, "My Album", true, false, true)
+		err := fixTags(logger, nil, mkOsReadDir, mkReadTags, mkWriteTags, providedtags, nil, OverwriteAllTags, TagCleanup{}, nil, "My Album", true, false, true)
 		if err != nil {
 			t.Fatalf("unexpected error: %s", err.Error())
 		}
@@ -356,7 +472,7 @@ func TestAlbumInfoToTags(t *testing.T) {
 	tests := []struct {
 		name     string
 		info     AlbumInfo
-		expected map[string]string
+		expected map[string][]string
 	}{
 		{
 			name: "happy path converts AlbumInfo to tags",
@@ -368,19 +484,19 @@ func TestAlbumInfoToTags(t *testing.T) {
 				CatalogNumber: []string{"123"},
 				AlbumType:     []string{"MyType", "OtherType"},
 			},
-			expected: map[string]string{
-				taglib.Album:         "MyAlbum",
-				taglib.Date:          "2021",
-				taglib.AlbumArtist:   "MyDev; OtherDev",
-				taglib.Label:         "MyPub; OtherPub",
-				taglib.CatalogNumber: "123",
-				taglib.Genre:         "MyType; OtherType",
+			expected: map[string][]string{
+				taglib.Album:         {"MyAlbum"},
+				taglib.Date:          {"2021"},
+				taglib.AlbumArtist:   {"MyDev", "OtherDev"},
+				taglib.Label:         {"MyPub", "OtherPub"},
+				taglib.CatalogNumber: {"123"},
+				taglib.Genre:         {"MyType", "OtherType"},
 			},
 		},
 		{
 			name:     "happy path converts AlbumInfo to tags with empty fields",
 			info:     AlbumInfo{},
-			expected: map[string]string{},
+			expected: map[string][]string{},
 		},
 		{
 			name: "happy path set publisher as artist when developer is empty",
@@ -392,11 +508,11 @@ func TestAlbumInfoToTags(t *testing.T) {
 				CatalogNumber: []string{},
 				AlbumType:     []string{},
 			},
-			expected: map[string]string{
-				taglib.Album:       "MyAlbum",
-				taglib.Date:        "2021",
-				taglib.AlbumArtist: "MyPub",
-				taglib.Label:       "MyPub",
+			expected: map[string][]string{
+				taglib.Album:       {"MyAlbum"},
+				taglib.Date:        {"2021"},
+				taglib.AlbumArtist: {"MyPub"},
+				taglib.Label:       {"MyPub"},
 			},
 		},
 	}
@@ -414,7 +530,7 @@ func TestAlbumInfoToFileTags(t *testing.T) {
 	tests := []struct {
 		name     string
 		info     AlbumInfo
-		expected map[string]map[string]string
+		expected map[string]map[string][]string
 	}{
 		{
 			name: "happy path converts AlbumInfo to file tags",
@@ -438,26 +554,26 @@ func TestAlbumInfoToFileTags(t *testing.T) {
 					},
 				},
 			},
-			expected: map[string]map[string]string{
+			expected: map[string]map[string][]string{
 				"1-01. Song1.flac": {
-					taglib.Title:       "Song1",
-					taglib.DiscNumber:  "1",
-					taglib.TrackNumber: "01",
+					taglib.Title:       {"Song1"},
+					taglib.DiscNumber:  {"1"},
+					taglib.TrackNumber: {"01"},
 				},
 				"1-01. My Song (By You).flac": {
-					taglib.Title:       "Song2",
-					taglib.TrackNumber: "02",
+					taglib.Title:       {"Song2"},
+					taglib.TrackNumber: {"02"},
 				},
 				"1-01. My Song (By You).mp3": {
-					taglib.Title:       "Song2",
-					taglib.TrackNumber: "02",
+					taglib.Title:       {"Song2"},
+					taglib.TrackNumber: {"02"},
 				},
 			},
 		},
 		{
 			name:     "happy path converts AlbumInfo to file tags with empty fields",
 			info:     AlbumInfo{Name: "MyAlbum"},
-			expected: map[string]map[string]string{},
+			expected: map[string]map[string][]string{},
 		},
 	}
 	for _, tt := range tests {
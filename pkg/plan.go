@@ -0,0 +1,402 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	URL "net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+const (
+	PlanImage = "image"
+	PlanTrack = "track"
+)
+
+const (
+	SkipDisabled          = "disabled"
+	SkipNotSelected       = "not selected"
+	SkipExists            = "exists"
+	SkipNoPreferredFormat = "no preferred format"
+	SkipFormatUnavailable = "format not available"
+)
+
+var ErrNoPreferredFormat = errors.New("no preferred format found")
+
+// Skips the item if its file exists, unless overwriting
+func skipExisting(logger *slog.Logger, osStat func(string) (os.FileInfo, error), item *PlanItem, overwrite bool) {
+	if overwrite {
+		return
+	}
+	if _, err := osStat(item.Path); err == nil {
+		logger.Info("skipped " + item.Path)
+		item.SkipReason = SkipExists
+	}
+}
+
+// Returns the error of an item skipped because of a failure, or nil if it was skipped on purpose.
+// Formats of -formats that a track lacks are skipped on purpose too
+func skipError(item *PlanItem) error {
+	switch item.SkipReason {
+	case SkipDisabled, SkipNotSelected, SkipExists, SkipFormatUnavailable:
+		return nil
+	case SkipNoPreferredFormat:
+		return ErrNoPreferredFormat
+	}
+	// Plans of earlier versions have failures to fetch a track page as the reason
+	return errors.New(item.SkipReason)
+}
+
+// Failure of one file of an album
+type ItemError struct {
+	// Path of the file, or the track name if it was not resolved
+	Path string
+	Err  error
+}
+
+func (e *ItemError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// Files that failed to download, in plan order. Matches errors.Is like errors.Join
+type DownloadErrors []*ItemError
+
+func (e DownloadErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d files failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e DownloadErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+type PlanItem struct {
+	Kind        string
+	Name        string `json:",omitzero"`
+	DiscNumber  string `json:",omitzero"`
+	TrackNumber string `json:",omitzero"`
+	// Track page to re-scrape if Url is empty or gone
+	PageUrl string `json:",omitzero"`
+	Url     string `json:",omitzero"`
+	Path    string `json:",omitzero"`
+	Format  string `json:",omitzero"`
+	// Expected size in bytes, zero if unknown
+	Size int64 `json:",omitzero"`
+	// Present if the file was probed to choose its format
+	Probe *AudioProbe `json:",omitzero"`
+	// Empty if the item is to be downloaded
+	SkipReason string `json:",omitzero"`
+	// Outcome once the plan is executed
+	Downloaded bool   `json:",omitzero"`
+	Error      string `json:",omitzero"`
+}
+
+type DownloadPlan struct {
+	Album  *AlbumInfo
+	Folder string
+	// Archive the album was downloaded into instead of Folder
+	Archive string `json:",omitzero"`
+	Items   []PlanItem
+	// Estimated size in bytes of the items to download
+	TotalSize int64 `json:",omitzero"`
+}
+
+func (p *DownloadPlan) updateTotalSize() {
+	p.TotalSize = 0
+	for _, item := range p.Items {
+		if item.SkipReason == "" {
+			p.TotalSize += item.Size
+		}
+	}
+}
+
+// Formats of -formats name files to download, unlike '*' and the quality tokens of a ranking
+func ValidateFormats(formats []string) error {
+	for _, format := range formats {
+		if !isFormatName(format) || slices.Contains([]string{RankLossless, RankLossy, RankLargest, RankSmallest}, strings.ToUpper(format)) {
+			return fmt.Errorf("invalid format: %q", format)
+		}
+	}
+	return nil
+}
+
+func isFormatName(format string) bool {
+	return format != "" && !strings.ContainsFunc(format, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Checks that each entry of a format preference is a format name, a ranking such as LOSSLESS,
+// or '*'
+func ValidateTrackFormatRanking(ranking []string) error {
+	for _, format := range ranking {
+		isRank := slices.Contains([]string{"*", RankLossless, RankLossy, RankLargest, RankSmallest, RankHighestBitrate}, strings.ToUpper(format))
+		if !isRank && !isFormatName(format) {
+			return fmt.Errorf("invalid format preference: %q", format)
+		}
+	}
+	return nil
+}
+
+func formatFolders(formats []string) map[string]string {
+	if len(formats) == 0 {
+		return nil
+	}
+	folders := make(map[string]string, len(formats))
+	for _, format := range formats {
+		folders[format] = sanitizeFilename(format)
+	}
+	return folders
+}
+
+// Chooses the files to download for a track: the best ranked format, or each format of
+// formatFolders into its own subfolder
+func selectTrackFiles(item PlanItem, folderName string, downloads map[string]TrackDownload, trackFormatRanking TrackFormatRanking, formatFolders map[string]string, probe func(string) (*AudioProbe, error)) []PlanItem {
+	if len(formatFolders) == 0 {
+		format, audio, ok := chooseTrackFormat(trackFormatRanking, downloads, probe)
+		if !ok {
+			item.SkipReason = SkipNoPreferredFormat
+			return []PlanItem{item}
+		}
+		download := downloads[format]
+		item.Url, item.Path, item.Format, item.Size, item.Probe = download.Url, downloadPath(folderName, download.Url), format, download.Size, audio
+		return []PlanItem{item}
+	}
+	items := make([]PlanItem, 0, len(formatFolders))
+	for _, format := range slices.Sorted(maps.Keys(formatFolders)) {
+		item := item
+		item.Format = format
+		if download, ok := downloads[format]; ok {
+			item.Url, item.Path, item.Size = download.Url, downloadPath(path.Join(folderName, formatFolders[format]), download.Url), download.Size
+		} else {
+			item.SkipReason = SkipFormatUnavailable
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func downloadPath(folderName, u string) string {
+	unescaped, _ := URL.QueryUnescape(u)
+	return path.Join(folderName, sanitizeFilename(path.Base(unescaped)))
+}
+
+// Resolves the album page and the pages of selected tracks. Nothing is written to disk
+func planAlbum(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	osStat func(string) (os.FileInfo, error),
+	workPath,
+	albumUrl string,
+	noDownloadImage,
+	noDownloadTrack,
+	overwrite bool,
+	trackNumberSet TrackNumberSet,
+	trackFormatRanking TrackFormatRanking,
+	formats []string,
+) (*DownloadPlan, error) {
+	logger.Info("fetching from " + albumUrl)
+	albumInfo, err := FetchAlbumInfo(ctx, httpClient, albumUrl)
+	if err != nil {
+		return nil, err
+	}
+	folderName := AlbumFolder(workPath, albumInfo)
+
+	logger.Info(
+		"fetched info",
+		"name", albumInfo.Name,
+		"year", albumInfo.Year,
+		"developer", albumInfo.Developer,
+		"publisher", albumInfo.Publisher,
+		"catalogNumber", albumInfo.CatalogNumber,
+		"albumType", albumInfo.AlbumType,
+		"images", len(albumInfo.Images),
+		"tracks", len(albumInfo.Tracks),
+		"folder", folderName,
+	)
+
+	plan := &DownloadPlan{Album: albumInfo, Folder: folderName, Items: []PlanItem{}}
+	albumInfo.FormatFolders = formatFolders(formats)
+	probe := newAudioProber(ctx, httpClient)
+	for _, imgInfo := range albumInfo.Images {
+		item := PlanItem{Kind: PlanImage, Url: imgInfo.ImageUrl, Path: downloadPath(folderName, imgInfo.ImageUrl)}
+		if noDownloadImage {
+			item.SkipReason = SkipDisabled
+		} else {
+			skipExisting(logger, osStat, &item, overwrite)
+		}
+		plan.Items = append(plan.Items, item)
+	}
+	if !noDownloadImage && len(albumInfo.Images) == 0 {
+		logger.Info("no images found")
+	}
+
+	for i := range albumInfo.Tracks {
+		t := &albumInfo.Tracks[i]
+		item := PlanItem{Kind: PlanTrack, Name: t.Name, DiscNumber: t.DiscNumber, TrackNumber: t.TrackNumber, PageUrl: t.PageUrl}
+		if noDownloadTrack {
+			item.SkipReason = SkipDisabled
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		if !trackNumberSet.Contains(t) {
+			logger.Debug("skipping track " + t.Name)
+			item.SkipReason = SkipNotSelected
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		downloads, err := FetchTrackDownloads(ctx, httpClient, t.PageUrl)
+		if err != nil {
+			// Left without a URL, so that the page is fetched again when downloading and a
+			// failure is reported with the other files
+			logger.Error("failed to fetch track download url: " + err.Error())
+			plan.Items = append(plan.Items, item)
+			continue
+		}
+		for format, download := range downloads {
+			t.SongUrl[format] = download.Url
+			if download.Size == 0 {
+				download.Size = t.Sizes[format]
+				downloads[format] = download
+			}
+		}
+		for _, item := range selectTrackFiles(item, folderName, downloads, trackFormatRanking, albumInfo.FormatFolders, probe) {
+			if item.SkipReason == SkipNoPreferredFormat {
+				logger.Info("no preferred format found for " + t.Name)
+			} else if item.SkipReason == "" {
+				skipExisting(logger, osStat, &item, overwrite)
+			}
+			plan.Items = append(plan.Items, item)
+		}
+	}
+	if !noDownloadTrack && len(albumInfo.Tracks) == 0 {
+		logger.Info("no tracks found")
+	}
+	if albumInfo.NumberOfFiles > 0 && albumInfo.NumberOfFiles != len(albumInfo.Tracks) {
+		logger.Warn("album page lists a different number of files than tracks found", "files", albumInfo.NumberOfFiles, "tracks", len(albumInfo.Tracks))
+	}
+
+	plan.updateTotalSize()
+	logger.Info("estimated download size", "size", FormatByteSize(plan.TotalSize))
+	return plan, nil
+}
+
+// Builds a plan into folderName from the URLs recorded in an info.json, without fetching pages.
+// Selected tracks without recorded URLs are left for executePlan to resolve from their pages
+func planFromAlbumInfo(
+	logger *slog.Logger,
+	probe func(string) (*AudioProbe, error),
+	osStat func(string) (os.FileInfo, error),
+	folderName string,
+	albumInfo *AlbumInfo,
+	noDownloadImage,
+	noDownloadTrack,
+	overwrite bool,
+	trackNumberSet TrackNumberSet,
+	trackFormatRanking TrackFormatRanking,
+	formats []string,
+) *DownloadPlan {
+	plan := &DownloadPlan{Album: albumInfo, Folder: folderName, Items: []PlanItem{}}
+	albumInfo.FormatFolders = formatFolders(formats)
+	for _, imgInfo := range albumInfo.Images {
+		item := PlanItem{Kind: PlanImage, Url: imgInfo.ImageUrl, Path: downloadPath(folderName, imgInfo.ImageUrl)}
+		if noDownloadImage {
+			item.SkipReason = SkipDisabled
+		} else {
+			skipExisting(logger, osStat, &item, overwrite)
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	for i := range albumInfo.Tracks {
+		t := &albumInfo.Tracks[i]
+		if t.SongUrl == nil {
+			t.SongUrl = map[string]string{}
+		}
+		item := PlanItem{Kind: PlanTrack, Name: t.Name, DiscNumber: t.DiscNumber, TrackNumber: t.TrackNumber, PageUrl: t.PageUrl}
+		if noDownloadTrack {
+			item.SkipReason = SkipDisabled
+		} else if !trackNumberSet.Contains(t) {
+			item.SkipReason = SkipNotSelected
+		} else if len(t.SongUrl) > 0 {
+			downloads := make(map[string]TrackDownload, len(t.SongUrl))
+			for format, u := range t.SongUrl {
+				downloads[format] = TrackDownload{Url: u, Size: t.Sizes[format]}
+			}
+			for _, item := range selectTrackFiles(item, folderName, downloads, trackFormatRanking, albumInfo.FormatFolders, probe) {
+				if item.SkipReason == SkipNoPreferredFormat {
+					logger.Info("no preferred format found for " + t.Name)
+				} else if item.SkipReason == "" {
+					skipExisting(logger, osStat, &item, overwrite)
+				}
+				plan.Items = append(plan.Items, item)
+			}
+			continue
+		} else {
+			// Resolved from the track page when executed
+			for _, format := range slices.Sorted(maps.Keys(albumInfo.FormatFolders)) {
+				item.Format = format
+				plan.Items = append(plan.Items, item)
+			}
+			if len(albumInfo.FormatFolders) > 0 {
+				continue
+			}
+		}
+		plan.Items = append(plan.Items, item)
+	}
+
+	plan.updateTotalSize()
+	logger.Info("estimated download size", "size", FormatByteSize(plan.TotalSize))
+	return plan
+}
+
+func PlanAlbum(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	logger *slog.Logger,
+	workPath,
+	albumUrl string,
+	noDownloadImage,
+	noDownloadTrack,
+	overwrite bool,
+	trackNumberSet TrackNumberSet,
+	trackFormatRanking TrackFormatRanking,
+	formats []string,
+	maxSize int64,
+	downgrade bool,
+) (*DownloadPlan, error) {
+	plan, err := planAlbum(ctx, httpClient, logger, os.Stat, workPath, albumUrl, noDownloadImage, noDownloadTrack, overwrite, trackNumberSet, trackFormatRanking, formats)
+	if err != nil {
+		return nil, err
+	}
+	if err := enforceBudget(ctx, httpClient, logger, DiskFreeSpace, plan, workPath, maxSize, downgrade); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func WriteDownloadPlanJSON(w io.Writer, plan *DownloadPlan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
@@ -0,0 +1,352 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanAlbum(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	song1CD := strings.ReplaceAll(song1, "01.%20song1", "1-01.%20song1")
+	client := stubClient{
+		"https://example.com/":                    {"GET": {http.StatusOK, home2}},
+		"https://example.com/1-01.%2520song1.mp3": {"GET": {http.StatusOK, song1CD}},
+		"https://example.com/1-01.%2520song2.mp3": {"GET": {http.StatusOK, strings.ReplaceAll(strings.ReplaceAll(song1CD, "song1", "song2"), "01", "02")}},
+	}
+
+	t.Run("happy path resolves formats, sizes and skip reasons", func(t *testing.T) {
+		mkStat := func(name string) (os.FileInfo, error) {
+			if name == "My Album 2/Cover.jpg" {
+				return nil, nil
+			}
+			return nil, os.ErrNotExist
+		}
+		set := TrackNumberSet{}
+		set.Add(TrackNumberKey{"1", "2"})
+
+		plan, err := planAlbum(context.Background(), client, logger, mkStat, ".", "https://example.com/", false, false, false, set, TrackFormatRanking{"MP3"}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if plan.Folder != "My Album 2" || plan.Album.Name != "My Album 2" {
+			t.Fatalf("expected folder My Album 2, got %s", plan.Folder)
+		}
+		expected := []PlanItem{
+			{Kind: PlanImage, Url: "https://download.com/Cover.jpg", Path: "My Album 2/Cover.jpg", SkipReason: SkipExists},
+			{Kind: PlanTrack, Name: "song1", DiscNumber: "1", TrackNumber: "1", PageUrl: "https://example.com/1-01.%2520song1.mp3", SkipReason: SkipNotSelected},
+			{Kind: PlanTrack, Name: "song2", DiscNumber: "1", TrackNumber: "2", PageUrl: "https://example.com/1-01.%2520song2.mp3", Url: "https://download.com/1-02.%20song2.mp3", Path: "My Album 2/1-02. song2.mp3", Format: "MP3", Size: 1 << 20},
+		}
+		if !reflect.DeepEqual(plan.Items, expected) {
+			t.Fatalf("expected %v, got %v", expected, plan.Items)
+		}
+		if plan.TotalSize != 1<<20 {
+			t.Fatalf("expected total size %d, got %d", 1<<20, plan.TotalSize)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteDownloadPlanJSON(&buf, plan); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var decoded DownloadPlan
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded.Items, expected) {
+			t.Fatalf("expected %v, got %v (err: %v)", expected, decoded.Items, err)
+		}
+	})
+
+	t.Run("happy path downloads each format into a subfolder", func(t *testing.T) {
+		mkStat := func(name string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+		set := TrackNumberSet{}
+		set.Add(TrackNumberKey{"1", "1"})
+		plan, err := planAlbum(context.Background(), client, logger, mkStat, ".", "https://example.com/", true, false, false, set, nil, []string{"MP3", "FLAC", "OGG"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expectedFolders := map[string]string{"FLAC": "FLAC", "MP3": "MP3", "OGG": "OGG"}
+		if !reflect.DeepEqual(plan.Album.FormatFolders, expectedFolders) {
+			t.Fatalf("expected %v, got %v", expectedFolders, plan.Album.FormatFolders)
+		}
+		pageUrl := "https://example.com/1-01.%2520song1.mp3"
+		expected := []PlanItem{
+			{Kind: PlanImage, Url: "https://download.com/Cover.jpg", Path: "My Album 2/Cover.jpg", SkipReason: SkipDisabled},
+			{Kind: PlanTrack, Name: "song1", DiscNumber: "1", TrackNumber: "1", PageUrl: pageUrl, Url: "https://download.com/1-01.%20song1.flac", Path: "My Album 2/FLAC/1-01. song1.flac", Format: "FLAC", Size: 1 << 20},
+			{Kind: PlanTrack, Name: "song1", DiscNumber: "1", TrackNumber: "1", PageUrl: pageUrl, Url: "https://download.com/1-01.%20song1.mp3", Path: "My Album 2/MP3/1-01. song1.mp3", Format: "MP3", Size: 1 << 20},
+			{Kind: PlanTrack, Name: "song1", DiscNumber: "1", TrackNumber: "1", PageUrl: pageUrl, Format: "OGG", SkipReason: SkipFormatUnavailable},
+			{Kind: PlanTrack, Name: "song2", DiscNumber: "1", TrackNumber: "2", PageUrl: "https://example.com/1-01.%2520song2.mp3", SkipReason: SkipNotSelected},
+		}
+		if !reflect.DeepEqual(plan.Items, expected) {
+			t.Fatalf("expected %v, got %v", expected, plan.Items)
+		}
+	})
+
+	t.Run("happy path marks disabled items and missing formats", func(t *testing.T) {
+		mkStat := func(name string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+		plan, err := planAlbum(context.Background(), client, logger, mkStat, ".", "https://example.com/", true, false, true, DownloadAllTracks, TrackFormatRanking{"OGG"}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, item := range plan.Items {
+			expected := SkipNoPreferredFormat
+			if item.Kind == PlanImage {
+				expected = SkipDisabled
+			}
+			if item.SkipReason != expected {
+				t.Fatalf("expected %s, got %s", expected, item.SkipReason)
+			}
+		}
+	})
+
+	t.Run("track pages failing are fetched again and reported when downloading", func(t *testing.T) {
+		client := stubClient{
+			"https://example.com/":                    client["https://example.com/"],
+			"https://example.com/1-01.%2520song1.mp3": {"GET": {http.StatusInternalServerError, ""}},
+		}
+		mkStat := func(name string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+		set := TrackNumberSet{}
+		set.Add(TrackNumberKey{"1", "1"})
+		plan, err := planAlbum(context.Background(), client, logger, mkStat, ".", "https://example.com/", true, false, false, set, TrackFormatRanking{"MP3"}, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := PlanItem{Kind: PlanTrack, Name: "song1", DiscNumber: "1", TrackNumber: "1", PageUrl: "https://example.com/1-01.%2520song1.mp3"}
+		if plan.Items[1] != expected || plan.TotalSize != 0 {
+			t.Fatalf("expected %v, got %v", expected, plan.Items[1])
+		}
+		mkMkdirAll := func(path string, perm os.FileMode) error { return nil }
+		mkFS := FSRecorder{}
+		err = executePlan(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkStat, plan, TrackFormatRanking{"MP3"}, true, true, false)
+		var downloadErrs DownloadErrors
+		if !errors.As(err, &downloadErrs) || len(downloadErrs) != 1 || downloadErrs[0].Path != "song1" {
+			t.Fatalf("expected song1 to fail, got %v", err)
+		}
+	})
+}
+
+func TestValidateFormats(t *testing.T) {
+	if err := ValidateFormats([]string{"FLAC", "MP3", "M4A"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, format := range []string{"*", "", "LOSSLESS", "HIGHEST_BITRATE", "FLAC/MP3"} {
+		if err := ValidateFormats([]string{"FLAC", format}); err == nil {
+			t.Fatalf("expected error for %q, got nil", format)
+		}
+	}
+}
+
+func TestValidateTrackFormatRanking(t *testing.T) {
+	if err := ValidateTrackFormatRanking([]string{"FLAC", "HIGHEST_BITRATE", "lossy", "*"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, format := range []string{"", "FLAC MP3", "BEST_QUALITY"} {
+		if err := ValidateTrackFormatRanking([]string{"FLAC", format}); err == nil {
+			t.Fatalf("expected error for %q, got nil", format)
+		}
+	}
+}
+
+func TestPlanFromAlbumInfoAndExecute(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	client := stubClient{
+		"https://example.com/":                  {"GET": {http.StatusOK, home1}},
+		"https://example.com/01.%2520song1.mp3": {"GET": {http.StatusOK, song1}},
+		"https://example.com/01.%2520song2.mp3": {"GET": {http.StatusOK, strings.ReplaceAll(strings.ReplaceAll(song1, "song1", "song2"), "01", "02")}},
+		"https://old.com/Cover.jpg":             {"GET": {http.StatusGone, ""}},
+		"https://old.com/01.%20song1.flac":      {"GET": {http.StatusNotFound, ""}},
+		"https://download.com/Cover.jpg":        {"GET": {http.StatusOK, "content of cover"}},
+		"https://download.com/01.%20song1.flac": {"GET": {http.StatusOK, "content of song1"}},
+		"https://download.com/02.%20song2.flac": {"GET": {http.StatusOK, "content of song2"}},
+	}
+	albumInfo := &AlbumInfo{
+		Url:    "https://example.com/",
+		Name:   "My Album 1",
+		Images: []ImageInfo{{ImageUrl: "https://old.com/Cover.jpg"}},
+		Tracks: []TrackInfo{
+			{Name: "song1", TrackNumber: "1", PageUrl: "https://example.com/01.%2520song1.mp3", SongUrl: map[string]string{"FLAC": "https://old.com/01.%20song1.flac"}},
+			{Name: "song2", TrackNumber: "2", PageUrl: "https://example.com/01.%2520song2.mp3"},
+		},
+	}
+	mkStat := func(name string) (os.FileInfo, error) { return nil, os.ErrNotExist }
+	mkMkdirAll := func(path string, perm os.FileMode) error { return nil }
+
+	plan := planFromAlbumInfo(logger, nil, mkStat, "My Album 1", albumInfo, false, false, false, DownloadAllTracks, TrackFormatRanking{"FLAC"}, nil)
+	expected := []PlanItem{
+		{Kind: PlanImage, Url: "https://old.com/Cover.jpg", Path: "My Album 1/Cover.jpg"},
+		{Kind: PlanTrack, Name: "song1", TrackNumber: "1", PageUrl: "https://example.com/01.%2520song1.mp3", Url: "https://old.com/01.%20song1.flac", Path: "My Album 1/01. song1.flac", Format: "FLAC"},
+		{Kind: PlanTrack, Name: "song2", TrackNumber: "2", PageUrl: "https://example.com/01.%2520song2.mp3"},
+	}
+	if !reflect.DeepEqual(plan.Items, expected) {
+		t.Fatalf("expected %v, got %v", expected, plan.Items)
+	}
+
+	mkFS := FSRecorder{}
+	if err := executePlan(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkStat, plan, TrackFormatRanking{"FLAC"}, false, true, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expDownloadedFiles := map[string]string{
+		"My Album 1/Cover.jpg":      "content of cover",
+		"My Album 1/01. song1.flac": "content of song1",
+		"My Album 1/02. song2.flac": "content of song2",
+	}
+	for path, content := range expDownloadedFiles {
+		if mkFS[path] != content {
+			t.Fatalf("expected %s to have content %s, got %s", path, content, mkFS[path])
+		}
+	}
+	if !strings.Contains(logs.String(), "differs from the expected size") {
+		t.Fatalf("expected a warning about the size of song2, got %s", logs.String())
+	}
+	// Re-scraped URLs are recorded
+	if !strings.Contains(mkFS["My Album 1/info.json"], "https://download.com/01.%20song1.flac") ||
+		!strings.Contains(mkFS["My Album 1/info.json"], "https://download.com/Cover.jpg") {
+		t.Fatalf("expected info.json to have new URLs, got %s", mkFS["My Album 1/info.json"])
+	}
+}
+
+func TestExecutePlanRescrapedExistingFiles(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	client := stubClient{
+		"https://example.com/01.%2520song2.mp3": {"GET": {http.StatusOK, strings.ReplaceAll(strings.ReplaceAll(song1, "song1", "song2"), "01", "02")}},
+		"https://download.com/02.%20song2.flac": {"GET": {http.StatusOK, "content of song2"}},
+	}
+	mkMkdirAll := func(path string, perm os.FileMode) error { return nil }
+	newPlan := func() *DownloadPlan {
+		return &DownloadPlan{Album: &AlbumInfo{Name: "My Album 1"}, Folder: "My Album 1", Items: []PlanItem{
+			{Kind: PlanTrack, Name: "song2", TrackNumber: "2", PageUrl: "https://example.com/01.%2520song2.mp3"},
+		}}
+	}
+
+	t.Run("happy path skips existing files", func(t *testing.T) {
+		mkFS := FSRecorder{"My Album 1/02. song2.flac": "existing"}
+		plan := newPlan()
+		if err := executePlan(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkFS.Stat, plan, TrackFormatRanking{"FLAC"}, true, true, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mkFS["My Album 1/02. song2.flac"] != "existing" || plan.Items[0].SkipReason != SkipExists {
+			t.Fatalf("expected %s to be skipped, got %v", "My Album 1/02. song2.flac", plan.Items[0])
+		}
+	})
+
+	t.Run("happy path overwrites existing files", func(t *testing.T) {
+		mkFS := FSRecorder{"My Album 1/02. song2.flac": "existing"}
+		if err := executePlan(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkFS.Stat, newPlan(), TrackFormatRanking{"FLAC"}, true, true, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mkFS["My Album 1/02. song2.flac"] != "content of song2" {
+			t.Fatalf("expected %s, got %s", "content of song2", mkFS["My Album 1/02. song2.flac"])
+		}
+	})
+}
+
+func TestExecutePlanPartialFiles(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	mkMkdirAll := func(path string, perm os.FileMode) error { return nil }
+	newPlan := func() *DownloadPlan {
+		return &DownloadPlan{Album: &AlbumInfo{Name: "Album"}, Folder: "Album", Items: []PlanItem{
+			{Kind: PlanTrack, Name: "song1", Url: "https://a.com/1.flac", Path: "Album/1.flac"},
+			{Kind: PlanTrack, Name: "song2", Url: "https://a.com/2.flac", Path: "Album/2.flac"},
+		}}
+	}
+
+	t.Run("happy path resumes a partial file", func(t *testing.T) {
+		client := &recordingClient{respond: func(req *http.Request) *http.Response {
+			if req.Header.Get("Range") == "bytes=8-" {
+				return htmlResponse(http.StatusPartialContent, "of song1", nil)
+			}
+			return htmlResponse(http.StatusOK, "content of "+req.URL.Path, nil)
+		}}
+		mkFS := FSRecorder{"Album/1.flac.part": "content "}
+		if err := executePlan(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkFS.Stat, newPlan(), nil, false, true, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mkFS["Album/1.flac"] != "content of song1" || mkFS["Album/2.flac"] != "content of /2.flac" {
+			t.Fatalf("expected resumed files, got %v", mkFS)
+		}
+		if _, ok := mkFS["Album/1.flac.part"]; ok {
+			t.Fatalf("expected partial file to be renamed")
+		}
+		if !strings.Contains(mkFS["Album/info.json"], `"Status": "complete"`) {
+			t.Fatalf("expected complete status, got %s", mkFS["Album/info.json"])
+		}
+	})
+
+	t.Run("stops when interrupted and records the status", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client := &recordingClient{respond: func(req *http.Request) *http.Response {
+			cancel()
+			return htmlResponse(http.StatusOK, "content", nil)
+		}}
+		mkFS := FSRecorder{}
+		if err := executePlan(ctx, client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkFS.Stat, newPlan(), nil, false, true, false); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %v, got %v", context.Canceled, err)
+		}
+		if len(client.requests) != 1 {
+			t.Fatalf("expected 1 request, got %d", len(client.requests))
+		}
+		if !strings.Contains(mkFS["Album/info.json"], `"Status": "interrupted"`) {
+			t.Fatalf("expected interrupted status, got %s", mkFS["Album/info.json"])
+		}
+	})
+}
+
+func TestSkipError(t *testing.T) {
+	pageErr := fmt.Errorf("failed to get page: %w", ErrNotFound)
+	tests := []struct {
+		item     PlanItem
+		expected error
+	}{
+		{PlanItem{SkipReason: SkipExists}, nil},
+		{PlanItem{SkipReason: SkipFormatUnavailable}, nil},
+		{PlanItem{SkipReason: SkipNoPreferredFormat}, ErrNoPreferredFormat},
+	}
+	for _, tt := range tests {
+		if err := skipError(&tt.item); !errors.Is(err, tt.expected) || (tt.expected == nil) != (err == nil) {
+			t.Fatalf("expected %v for %s, got %v", tt.expected, tt.item.SkipReason, err)
+		}
+	}
+	// Plans of earlier versions only have the message
+	if err := skipError(&PlanItem{SkipReason: pageErr.Error()}); err == nil || err.Error() != pageErr.Error() {
+		t.Fatalf("expected %v, got %v", pageErr, err)
+	}
+}
+
+func TestExecutePlanErrors(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	client := stubClient{
+		"https://a.com/1.flac": {"GET": {http.StatusOK, "content of song1"}},
+		"https://a.com/2.flac": {"GET": {http.StatusInternalServerError, ""}},
+	}
+	plan := &DownloadPlan{Album: &AlbumInfo{Name: "Album"}, Folder: "Album", Items: []PlanItem{
+		{Kind: PlanImage, Path: "Album/cover.jpg", SkipReason: SkipExists},
+		{Kind: PlanTrack, Name: "song1", Url: "https://a.com/1.flac", Path: "Album/1.flac"},
+		{Kind: PlanTrack, Name: "song2", Url: "https://a.com/2.flac", Path: "Album/2.flac"},
+		{Kind: PlanTrack, Name: "song3", SkipReason: SkipNoPreferredFormat},
+		{Kind: PlanTrack, Name: "song3", Format: "MP3", SkipReason: SkipFormatUnavailable},
+	}}
+	mkMkdirAll := func(path string, perm os.FileMode) error { return nil }
+	mkFS := FSRecorder{}
+
+	err := executePlan(context.Background(), client, logger, mkMkdirAll, mkFS.Create, mkFS.Append, mkFS.Rename, mkFS.Stat, plan, nil, true, true, false)
+	var downloadErrs DownloadErrors
+	if !errors.As(err, &downloadErrs) || len(downloadErrs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if downloadErrs[0].Path != "Album/2.flac" || downloadErrs[1].Path != "song3" || !errors.Is(downloadErrs[1], ErrNoPreferredFormat) {
+		t.Fatalf("unexpected errors: %v", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v not to match %v", err, ErrNotFound)
+	}
+	if mkFS["Album/1.flac"] != "content of song1" || plan.Album.Status != StatusIncomplete {
+		t.Fatalf("expected song1 to be downloaded and status %s, got %v and %s", StatusIncomplete, mkFS, plan.Album.Status)
+	}
+}
@@ -0,0 +1,366 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	URL "net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Ranking tokens that choose a format by quality instead of by name
+const (
+	// Any lossless format, the largest if several
+	RankLossless = "LOSSLESS"
+	// Any lossy format, the largest if several
+	RankLossy          = "LOSSY"
+	RankLargest        = "LARGEST"
+	RankSmallest       = "SMALLEST"
+	RankHighestBitrate = "HIGHEST_BITRATE"
+)
+
+var losslessFormats = InsStringKeySet{"FLAC": {}, "WAV": {}, "AIFF": {}, "APE": {}, "WV": {}, "ALAC": {}}
+
+// Formats that may hold either lossless or lossy audio
+var ambiguousFormats = InsStringKeySet{"M4A": {}, "MP4": {}}
+
+// Audio properties read from the beginning of a file
+type AudioProbe struct {
+	Codec    string
+	Lossless bool
+	// Zero if unknown
+	SampleRate    int `json:",omitzero"`
+	BitsPerSample int `json:",omitzero"`
+	Channels      int `json:",omitzero"`
+	// Bits per second, zero if unknown
+	Bitrate int `json:",omitzero"`
+	// Size of the whole file from Content-Range, zero if unknown
+	Size int64 `json:",omitzero"`
+}
+
+const probeLength = 64 << 10
+
+// Fetches the first bytes of a file with a ranged request and reads its audio header
+func ProbeAudio(ctx context.Context, httpClient HttpDoClient, url string) (*AudioProbe, error) {
+	data, size, err := getRange(ctx, httpClient, url, 0)
+	if err != nil {
+		return nil, err
+	}
+	// ID3v2 tags may hold large pictures before the first audio frame
+	if tagEnd := id3v2Size(data); tagEnd > 0 {
+		if tagEnd+probeLength/2 <= int64(len(data)) {
+			data = data[tagEnd:]
+		} else if data, _, err = getRange(ctx, httpClient, url, tagEnd); err != nil {
+			return nil, err
+		}
+	}
+	return parseAudioHeader(data, size)
+}
+
+func newAudioProber(ctx context.Context, httpClient HttpDoClient) func(string) (*AudioProbe, error) {
+	return func(u string) (*AudioProbe, error) {
+		unescaped, _ := URL.QueryUnescape(u)
+		return ProbeAudio(ctx, httpClient, unescaped)
+	}
+}
+
+func getRange(ctx context.Context, httpClient HttpDoClient, url string, offset int64) ([]byte, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+probeLength-1))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var size int64
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		// bytes 0-65535/12345678
+		if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			size, _ = strconv.ParseInt(total, 10, 64)
+		}
+	case http.StatusOK:
+		// Range not supported, so skip to the offset of the whole file
+		size = max(resp.ContentLength, 0)
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return nil, 0, err
+		}
+	default:
+		return nil, 0, &HttpStatusError{resp.StatusCode}
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, probeLength))
+	return data, size, err
+}
+
+// Total size of the ID3v2 tag at the start of data, or 0
+func id3v2Size(data []byte) int64 {
+	if len(data) < 10 || !bytes.HasPrefix(data, []byte("ID3")) {
+		return 0
+	}
+	size := int64(data[6])<<21 | int64(data[7])<<14 | int64(data[8])<<7 | int64(data[9]) + 10
+	if data[5]&0x10 != 0 {
+		// Footer
+		size += 10
+	}
+	return size
+}
+
+// size is that of the whole file, used to compute the average bitrate if known
+func parseAudioHeader(data []byte, size int64) (*AudioProbe, error) {
+	var probe *AudioProbe
+	switch {
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		probe = parseFlacHeader(data, size)
+	case bytes.HasPrefix(data, []byte("OggS")):
+		probe = parseOggHeader(data)
+	case len(data) >= 12 && bytes.HasPrefix(data, []byte("RIFF")) && string(data[8:12]) == "WAVE":
+		probe = parseWavHeader(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		probe = parseMp4Header(data)
+	default:
+		probe = parseMp3Header(data, size)
+	}
+	if probe == nil {
+		return nil, fmt.Errorf("unknown audio format")
+	}
+	probe.Size = size
+	return probe, nil
+}
+
+func averageBitrate(size, samples int64, sampleRate int) int {
+	if size <= 0 || samples <= 0 || sampleRate <= 0 {
+		return 0
+	}
+	return int(size * 8 * int64(sampleRate) / samples)
+}
+
+func parseFlacHeader(data []byte, size int64) *AudioProbe {
+	// "fLaC", then the metadata block header of STREAMINFO
+	if len(data) < 8+18 || data[4]&0x7f != 0 {
+		return nil
+	}
+	b := data[8:]
+	sampleRate := int(b[10])<<12 | int(b[11])<<4 | int(b[12])>>4
+	totalSamples := int64(b[13]&0xf)<<32 | int64(binary.BigEndian.Uint32(b[14:18]))
+	return &AudioProbe{
+		Codec:         "FLAC",
+		Lossless:      true,
+		SampleRate:    sampleRate,
+		Channels:      int(b[12]>>1&0x7) + 1,
+		BitsPerSample: (int(b[12]&0x1)<<4 | int(b[13]>>4)) + 1,
+		Bitrate:       averageBitrate(size, totalSamples, sampleRate),
+	}
+}
+
+func parseOggHeader(data []byte) *AudioProbe {
+	if len(data) < 27 {
+		return nil
+	}
+	packetStart := 27 + int(data[26])
+	if len(data) < packetStart+28 {
+		return nil
+	}
+	packet := data[packetStart:]
+	switch {
+	case bytes.HasPrefix(packet, []byte("\x01vorbis")):
+		return &AudioProbe{
+			Codec:      "VORBIS",
+			Channels:   int(packet[11]),
+			SampleRate: int(binary.LittleEndian.Uint32(packet[12:16])),
+			Bitrate:    max(int(int32(binary.LittleEndian.Uint32(packet[20:24]))), 0),
+		}
+	case bytes.HasPrefix(packet, []byte("OpusHead")):
+		return &AudioProbe{
+			Codec:      "OPUS",
+			Channels:   int(packet[9]),
+			SampleRate: int(binary.LittleEndian.Uint32(packet[12:16])),
+		}
+	case bytes.HasPrefix(packet, []byte("\x7fFLAC")):
+		probe := parseFlacHeader(packet[9:], 0)
+		if probe != nil {
+			probe.Codec = "OGG FLAC"
+		}
+		return probe
+	}
+	return nil
+}
+
+func parseWavHeader(data []byte) *AudioProbe {
+	for offset := 12; offset+8 <= len(data); {
+		chunkSize := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		if string(data[offset:offset+4]) == "fmt " && offset+24 <= len(data) {
+			f := data[offset+8:]
+			return &AudioProbe{
+				Codec:         "PCM",
+				Lossless:      true,
+				Channels:      int(binary.LittleEndian.Uint16(f[2:4])),
+				SampleRate:    int(binary.LittleEndian.Uint32(f[4:8])),
+				Bitrate:       int(binary.LittleEndian.Uint32(f[8:12])) * 8,
+				BitsPerSample: int(binary.LittleEndian.Uint16(f[14:16])),
+			}
+		}
+		offset += 8 + chunkSize + chunkSize%2
+	}
+	return nil
+}
+
+// Only looks for the codec in the sample description, which is missing if the moov box is at the end
+func parseMp4Header(data []byte) *AudioProbe {
+	stsd := bytes.Index(data, []byte("stsd"))
+	if stsd < 0 {
+		return &AudioProbe{Codec: "UNKNOWN"}
+	}
+	entry := data[stsd:]
+	switch {
+	case bytes.Contains(entry[:min(len(entry), 64)], []byte("alac")):
+		return &AudioProbe{Codec: "ALAC", Lossless: true}
+	case bytes.Contains(entry[:min(len(entry), 64)], []byte("mp4a")):
+		return &AudioProbe{Codec: "AAC"}
+	}
+	return &AudioProbe{Codec: "UNKNOWN"}
+}
+
+var (
+	mp3BitratesV1 = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mp3BitratesV2 = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	mp3SampleRate = [4]int{44100, 48000, 32000, 0}
+)
+
+// Reads the first MPEG layer III frame header, and the Xing or Info header of VBR files if present
+func parseMp3Header(data []byte, size int64) *AudioProbe {
+	for i := 0; i+4 <= len(data); i++ {
+		if data[i] != 0xff || data[i+1]&0xe0 != 0xe0 {
+			continue
+		}
+		version := data[i+1] >> 3 & 0x3 // 0: 2.5, 2: 2, 3: 1
+		layer := data[i+1] >> 1 & 0x3   // 1: III
+		bitrateIndex := data[i+2] >> 4
+		sampleRateIndex := data[i+2] >> 2 & 0x3
+		if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+			continue
+		}
+		probe := &AudioProbe{Codec: "MP3", Channels: 2}
+		if data[i+3]>>6 == 3 {
+			probe.Channels = 1
+		}
+		samplesPerFrame := int64(1152)
+		switch version {
+		case 3:
+			probe.SampleRate = mp3SampleRate[sampleRateIndex]
+			probe.Bitrate = mp3BitratesV1[bitrateIndex] * 1000
+		case 2:
+			probe.SampleRate = mp3SampleRate[sampleRateIndex] / 2
+			probe.Bitrate = mp3BitratesV2[bitrateIndex] * 1000
+			samplesPerFrame = 576
+		case 0:
+			probe.SampleRate = mp3SampleRate[sampleRateIndex] / 4
+			probe.Bitrate = mp3BitratesV2[bitrateIndex] * 1000
+			samplesPerFrame = 576
+		}
+		frame := data[i:min(len(data), i+200)]
+		xing := bytes.Index(frame, []byte("Xing"))
+		if xing < 0 {
+			xing = bytes.Index(frame, []byte("Info"))
+		}
+		if xing >= 0 && xing+12 <= len(frame) && binary.BigEndian.Uint32(frame[xing+4:])&0x1 != 0 {
+			frames := int64(binary.BigEndian.Uint32(frame[xing+8:]))
+			if bitrate := averageBitrate(size, frames*samplesPerFrame, probe.SampleRate); bitrate > 0 {
+				probe.Bitrate = bitrate
+			}
+		}
+		return probe
+	}
+	return nil
+}
+
+// Chooses a format from downloads by the ranking, which may contain format names, "*" and the
+// quality tokens such as RankLossless. probe may be nil, in which case files are not probed and
+// only the sizes shown on track pages are used
+func chooseTrackFormat(ranking TrackFormatRanking, downloads map[string]TrackDownload, probe func(string) (*AudioProbe, error)) (string, *AudioProbe, bool) {
+	formats := slices.Sorted(maps.Keys(downloads))
+	probes := map[string]*AudioProbe{}
+	probeOf := func(format string) *AudioProbe {
+		if p, ok := probes[format]; ok || probe == nil {
+			return p
+		}
+		p, err := probe(downloads[format].Url)
+		if err != nil {
+			p = nil
+		}
+		probes[format] = p
+		return p
+	}
+	sizeOf := func(format string) int64 {
+		if size := downloads[format].Size; size > 0 {
+			return size
+		}
+		if p := probeOf(format); p != nil {
+			return p.Size
+		}
+		return 0
+	}
+	isLossless := func(format string) bool {
+		if losslessFormats.Contains(format) {
+			return true
+		}
+		if ambiguousFormats.Contains(format) {
+			p := probeOf(format)
+			return p != nil && p.Lossless
+		}
+		return false
+	}
+	best := func(candidates []string, better func(a, b string) bool) (string, bool) {
+		if len(candidates) == 0 {
+			return "", false
+		}
+		chosen := candidates[0]
+		for _, c := range candidates[1:] {
+			if better(c, chosen) {
+				chosen = c
+			}
+		}
+		return chosen, true
+	}
+	larger := func(a, b string) bool { return sizeOf(a) > sizeOf(b) }
+
+	for _, token := range ranking {
+		var format string
+		var ok bool
+		switch token {
+		case RankLossless, RankLossy:
+			candidates := slices.DeleteFunc(slices.Clone(formats), func(f string) bool { return isLossless(f) != (token == RankLossless) })
+			format, ok = best(candidates, larger)
+		case RankLargest:
+			format, ok = best(formats, larger)
+		case RankSmallest:
+			format, ok = best(formats, func(a, b string) bool {
+				sa, sb := sizeOf(a), sizeOf(b)
+				return sa > 0 && (sb == 0 || sa < sb)
+			})
+		case RankHighestBitrate:
+			candidates := slices.DeleteFunc(slices.Clone(formats), func(f string) bool {
+				p := probeOf(f)
+				return p == nil || p.Bitrate == 0
+			})
+			format, ok = best(candidates, func(a, b string) bool { return probeOf(a).Bitrate > probeOf(b).Bitrate })
+		case "*":
+			format, ok = best(formats, func(a, b string) bool { return false })
+		default:
+			_, ok = downloads[token]
+			format = token
+		}
+		if ok {
+			return format, probes[format], true
+		}
+	}
+	return "", nil, false
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// 44100 Hz, 2 channels, 16 bits, 441000 samples (10 s)
+func flacHeader() []byte {
+	b := []byte("fLaC\x00\x00\x00\x22")
+	info := make([]byte, 34)
+	info[10] = 44100 >> 12
+	info[11] = 44100 >> 4 & 0xff
+	info[12] = byte(44100&0xf)<<4 | 1<<1 | 0
+	info[13] = 15 << 4
+	binary.BigEndian.PutUint32(info[14:18], 441000)
+	return append(b, info...)
+}
+
+// MPEG 1 layer III, 320 kbps, 44100 Hz, stereo
+func mp3Frame() []byte {
+	return append([]byte{0xff, 0xfb, 0xe0, 0x00}, make([]byte, 100)...)
+}
+
+func wavHeader() []byte {
+	b := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00")
+	f := make([]byte, 16)
+	binary.LittleEndian.PutUint16(f[0:2], 1)
+	binary.LittleEndian.PutUint16(f[2:4], 2)
+	binary.LittleEndian.PutUint32(f[4:8], 48000)
+	binary.LittleEndian.PutUint32(f[8:12], 48000*4)
+	binary.LittleEndian.PutUint16(f[12:14], 4)
+	binary.LittleEndian.PutUint16(f[14:16], 16)
+	return append(b, f...)
+}
+
+func TestParseAudioHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		size     int64
+		expected AudioProbe
+	}{
+		{"flac", flacHeader(), 1250000, AudioProbe{Codec: "FLAC", Lossless: true, SampleRate: 44100, BitsPerSample: 16, Channels: 2, Bitrate: 1000000, Size: 1250000}},
+		{"mp3 after garbage", append([]byte{0, 1, 2}, mp3Frame()...), 0, AudioProbe{Codec: "MP3", SampleRate: 44100, Channels: 2, Bitrate: 320000}},
+		{"wav", wavHeader(), 0, AudioProbe{Codec: "PCM", Lossless: true, SampleRate: 48000, BitsPerSample: 16, Channels: 2, Bitrate: 1536000}},
+		{"m4a with alac", []byte("\x00\x00\x00\x20ftypM4A moov....stsd\x00\x00\x00\x00\x00\x00\x00\x01....alac"), 0, AudioProbe{Codec: "ALAC", Lossless: true}},
+		{"m4a with aac", []byte("\x00\x00\x00\x20ftypM4A moov....stsd\x00\x00\x00\x00\x00\x00\x00\x01....mp4a"), 0, AudioProbe{Codec: "AAC"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe, err := parseAudioHeader(tt.data, tt.size)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*probe, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, *probe)
+			}
+		})
+	}
+
+	if _, err := parseAudioHeader([]byte("not audio"), 0); err == nil {
+		t.Fatalf("expected error")
+	}
+}
+
+func TestProbeAudio(t *testing.T) {
+	// ID3v2 tag larger than the probe window, followed by an MP3 frame
+	tagSize := probeLength + 100
+	file := append([]byte("ID3\x04\x00\x00"), byte(tagSize>>21&0x7f), byte(tagSize>>14&0x7f), byte(tagSize>>7&0x7f), byte(tagSize&0x7f))
+	file = append(file, make([]byte, tagSize)...)
+	file = append(file, mp3Frame()...)
+
+	client := &recordingClient{respond: func(req *http.Request) *http.Response {
+		var start, end int
+		fmt.Sscanf(req.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		end = min(end, len(file)-1)
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Header:     http.Header{"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", start, end, len(file))}},
+			Body:       io.NopCloser(bytes.NewReader(file[start : end+1])),
+		}
+	}}
+	probe, err := ProbeAudio(context.Background(), client, "https://download.com/song.mp3")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if probe.Codec != "MP3" || probe.Bitrate != 320000 || probe.Size != int64(len(file)) {
+		t.Fatalf("unexpected probe: %v", *probe)
+	}
+	if len(client.requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(client.requests))
+	}
+}
+
+func TestChooseTrackFormat(t *testing.T) {
+	downloads := map[string]TrackDownload{
+		"MP3": {Url: "mp3", Size: 8 << 20},
+		"OGG": {Url: "ogg", Size: 6 << 20},
+		"M4A": {Url: "m4a", Size: 30 << 20},
+	}
+	probes := map[string]*AudioProbe{
+		"mp3": {Codec: "MP3", Bitrate: 320000},
+		"ogg": {Codec: "VORBIS", Bitrate: 500000},
+		"m4a": {Codec: "ALAC", Lossless: true},
+	}
+	probe := func(u string) (*AudioProbe, error) { return probes[u], nil }
+
+	tests := []struct {
+		ranking  TrackFormatRanking
+		probe    func(string) (*AudioProbe, error)
+		expected string
+	}{
+		// M4A is found to be lossless only by probing
+		{TrackFormatRanking{"FLAC", "LOSSY"}, probe, "MP3"},
+		{TrackFormatRanking{"FLAC", "LOSSY"}, nil, "M4A"},
+		{TrackFormatRanking{"LOSSLESS", "MP3"}, probe, "M4A"},
+		{TrackFormatRanking{"LOSSLESS", "MP3"}, nil, "MP3"},
+		{TrackFormatRanking{"SMALLEST"}, nil, "OGG"},
+		{TrackFormatRanking{"HIGHEST_BITRATE"}, probe, "OGG"},
+		{TrackFormatRanking{"HIGHEST_BITRATE", "MP3"}, nil, "MP3"},
+		{TrackFormatRanking{"*"}, nil, "M4A"},
+	}
+	for _, tt := range tests {
+		format, _, ok := chooseTrackFormat(tt.ranking, downloads, tt.probe)
+		if !ok || format != tt.expected {
+			t.Fatalf("expected %s for %v, got %s", tt.expected, tt.ranking, format)
+		}
+	}
+
+	if _, _, ok := chooseTrackFormat(TrackFormatRanking{"FLAC"}, downloads, probe); ok {
+		t.Fatalf("expected no format")
+	}
+}
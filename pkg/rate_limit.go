@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Token bucket that lets callers reserve tokens ahead of time, so that concurrent callers
+// wait in turn. A rate of 0 means unlimited
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := float64(max(burst, 1))
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: now}
+}
+
+// Returns how long to wait before using n tokens
+func (b *tokenBucket) reserve(n float64, now time.Time) time.Duration {
+	if b.rate <= 0 {
+		return 0
+	}
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type hostLimiter struct {
+	bucket *tokenBucket
+	// Time the last request was allowed to start
+	lastStart time.Time
+}
+
+// Wraps a client to limit requests per host with a token bucket and a minimum delay
+// between requests, and to cap the bandwidth of all files downloaded together
+type RateLimitedClient struct {
+	client            HttpDoClient
+	requestsPerSecond float64
+	burst             int
+	minDelay          time.Duration
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu         sync.Mutex
+	hosts      map[string]*hostLimiter
+	bandwidth  *tokenBucket
+	bodyChunks int
+}
+
+// Zero values mean no limit
+func NewRateLimitedClient(client HttpDoClient, requestsPerSecond float64, burst int, minDelay time.Duration, bytesPerSecond int64) *RateLimitedClient {
+	c := &RateLimitedClient{
+		client:            client,
+		requestsPerSecond: requestsPerSecond,
+		burst:             burst,
+		minDelay:          minDelay,
+		now:               time.Now,
+		sleep:             sleepContext,
+		hosts:             map[string]*hostLimiter{},
+	}
+	// Allow reading up to a second worth of data at once
+	c.bodyChunks = int(min(max(bytesPerSecond, 1), 32*1024))
+	c.bandwidth = newTokenBucket(float64(bytesPerSecond), int(bytesPerSecond), c.now())
+	return c
+}
+
+type fileDownloadKey struct{}
+
+// Marks requests of files to download, whose bodies are limited by the bandwidth cap unlike pages
+func withFileDownload(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fileDownloadKey{}, true)
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// How long to wait before a request to the host can start
+func (c *RateLimitedClient) reserveRequest(host string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	h, ok := c.hosts[host]
+	if !ok {
+		h = &hostLimiter{bucket: newTokenBucket(c.requestsPerSecond, c.burst, now)}
+		c.hosts[host] = h
+	}
+	wait := h.bucket.reserve(1, now)
+	if !h.lastStart.IsZero() {
+		wait = max(wait, h.lastStart.Add(c.minDelay).Sub(now))
+	}
+	h.lastStart = now.Add(wait)
+	return wait
+}
+
+func (c *RateLimitedClient) reserveBytes(n int) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bandwidth.reserve(float64(n), c.now())
+}
+
+func (c *RateLimitedClient) Do(req *http.Request) (*http.Response, error) {
+	if err := c.sleep(req.Context(), c.reserveRequest(req.URL.Host)); err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil || c.bandwidth.rate <= 0 || req.Context().Value(fileDownloadKey{}) == nil {
+		return resp, err
+	}
+	resp.Body = &throttledBody{ReadCloser: resp.Body, ctx: req.Context(), client: c}
+	return resp, nil
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	client *RateLimitedClient
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	if len(p) > b.client.bodyChunks {
+		p = p[:b.client.bodyChunks]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		if err := b.client.sleep(b.ctx, b.client.reserveBytes(n)); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now    time.Time
+	slept  []time.Duration
+	cancel bool
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if c.cancel {
+		return context.Canceled
+	}
+	c.slept = append(c.slept, d)
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	return nil
+}
+
+func newTestRateLimitedClient(client HttpDoClient, clock *fakeClock, requestsPerSecond float64, burst int, minDelay time.Duration, bytesPerSecond int64) *RateLimitedClient {
+	c := NewRateLimitedClient(client, requestsPerSecond, burst, minDelay, bytesPerSecond)
+	c.now = clock.Now
+	c.sleep = clock.Sleep
+	c.bandwidth.last = clock.now
+	return c
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	client := stubClient{
+		"https://a.com/": {"GET": {http.StatusOK, "content of a"}},
+		"https://b.com/": {"GET": {http.StatusOK, "content of b"}},
+	}
+	getFrom := func(ctx context.Context, c *RateLimitedClient, url string) string {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		content, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return string(content)
+	}
+	get := func(c *RateLimitedClient, url string) string {
+		return getFrom(context.Background(), c, url)
+	}
+
+	t.Run("happy path limits requests per host", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := newTestRateLimitedClient(client, clock, 2, 2, 0, 0)
+		for range 4 {
+			get(c, "https://a.com/")
+		}
+		get(c, "https://b.com/")
+		expected := []time.Duration{0, 0, 500 * time.Millisecond, 500 * time.Millisecond, 0}
+		if len(clock.slept) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, clock.slept)
+		}
+		for i := range expected {
+			if clock.slept[i] != expected[i] {
+				t.Fatalf("expected %v, got %v", expected, clock.slept)
+			}
+		}
+	})
+
+	t.Run("happy path waits for minimum delay", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := newTestRateLimitedClient(client, clock, 0, 0, time.Second, 0)
+		get(c, "https://a.com/")
+		clock.now = clock.now.Add(300 * time.Millisecond)
+		get(c, "https://a.com/")
+		expected := []time.Duration{0, 700 * time.Millisecond}
+		if clock.slept[0] != expected[0] || clock.slept[1] != expected[1] {
+			t.Fatalf("expected %v, got %v", expected, clock.slept)
+		}
+	})
+
+	t.Run("happy path caps bandwidth of files", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		c := newTestRateLimitedClient(client, clock, 0, 0, 0, 4)
+		// Pages are not limited
+		get(c, "https://b.com/")
+		if content := getFrom(withFileDownload(context.Background()), c, "https://a.com/"); content != "content of a" {
+			t.Fatalf("unexpected content: %s", content)
+		}
+		// 12 bytes at 4 bytes per second with a burst of 4 bytes
+		var total time.Duration
+		for _, d := range clock.slept {
+			total += d
+		}
+		if total != 2*time.Second {
+			t.Fatalf("expected to wait 2s in total, got %v", clock.slept)
+		}
+	})
+
+	t.Run("cancelled while waiting", func(t *testing.T) {
+		clock := &fakeClock{now: time.Unix(0, 0), cancel: true}
+		c := newTestRateLimitedClient(client, clock, 1, 1, 0, 0)
+		req, _ := http.NewRequest(http.MethodGet, "https://a.com/", nil)
+		if _, err := c.Do(req); err == nil || !strings.Contains(err.Error(), "canceled") {
+			t.Fatalf("expected error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var byteSizeRegex = regexp.MustCompile(`^\s*([\d.]+)\s*([a-zA-Z]*)\s*$`)
+
+var byteSizeUnits = map[string]int64{
+	"":  1,
+	"B": 1,
+	"K": 1 << 10, "KB": 1 << 10, "KIB": 1 << 10,
+	"M": 1 << 20, "MB": 1 << 20, "MIB": 1 << 20,
+	"G": 1 << 30, "GB": 1 << 30, "GIB": 1 << 30,
+	"T": 1 << 40, "TB": 1 << 40, "TIB": 1 << 40,
+}
+
+// Parses sizes like "512", "1.5 MB" or "2GiB". Units are multiples of 1024 like on the site
+func ParseByteSize(s string) (int64, error) {
+	match := byteSizeRegex.FindStringSubmatch(s)
+	if match == nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	unit, ok := byteSizeUnits[strings.ToUpper(match[2])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit: %s", match[2])
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+func FormatByteSize(size int64) string {
+	for _, unit := range []string{"TB", "GB", "MB", "KB"} {
+		if m := byteSizeUnits[unit]; size >= m {
+			return strconv.FormatFloat(float64(size)/float64(m), 'f', 2, 64) + " " + unit
+		}
+	}
+	return strconv.FormatInt(size, 10) + " B"
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"512", 512},
+		{"1 MB", 1 << 20},
+		{"1.5mb", 3 << 19},
+		{" 2GiB ", 2 << 30},
+		{"100K", 100 << 10},
+		{"3 B", 3},
+	}
+	for _, tt := range tests {
+		if res, err := ParseByteSize(tt.input); err != nil || res != tt.expected {
+			t.Fatalf("expected %d for %s, got %d (err: %v)", tt.expected, tt.input, res, err)
+		}
+	}
+	for _, input := range []string{"", "MB", "1 XB", "1..2 MB"} {
+		if _, err := ParseByteSize(input); err == nil {
+			t.Fatalf("expected error for %s", input)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input    int64
+		expected string
+	}{
+		{512, "512 B"},
+		{3 << 19, "1.50 MB"},
+		{2 << 30, "2.00 GB"},
+	}
+	for _, tt := range tests {
+		if res := FormatByteSize(tt.input); res != tt.expected {
+			t.Fatalf("expected %s for %d, got %s", tt.expected, tt.input, res)
+		}
+	}
+}
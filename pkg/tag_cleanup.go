@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Removals and value rules applied to the tags of a file before writing
+type TagCleanup struct {
+	// Existing tags to remove
+	Remove TagKeySet
+	// If not empty, all other tags are removed
+	KeepOnly TagKeySet
+	Rules    []TagValueRule
+}
+
+const (
+	TagValueReplace = "replace"
+	TagValueUpper   = "upper"
+	TagValueLower   = "lower"
+	TagValueTitle   = "title"
+	TagValueFirst   = "first"
+	TagValueUnique  = "unique"
+)
+
+type TagValueRule struct {
+	Keys   TagKeySet
+	Action string
+	// Only used by replace
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// Format: KEY[,KEY...]:ACTION where ACTION is one of
+// s/regex/replacement/[i], upper, lower, title, first or unique.
+// Any character can be used as the delimiter in place of "/"
+func ParseTagValueRule(value string) (TagValueRule, error) {
+	keys, action, ok := strings.Cut(value, ":")
+	if !ok || keys == "" {
+		return TagValueRule{}, fmt.Errorf("invalid rule format: %s", value)
+	}
+	rule := TagValueRule{Keys: TagKeySet{}}
+	for key := range strings.SplitSeq(keys, ",") {
+		rule.Keys.Add(strings.TrimSpace(key))
+	}
+	switch strings.ToLower(action) {
+	case TagValueUpper, TagValueLower, TagValueTitle, TagValueFirst, TagValueUnique:
+		rule.Action = strings.ToLower(action)
+		return rule, nil
+	}
+	if !strings.HasPrefix(action, "s") || len(action) < 2 {
+		return TagValueRule{}, fmt.Errorf("unknown rule action: %s", action)
+	}
+	delim, size := utf8.DecodeRuneInString(action[1:])
+	parts := strings.Split(action[1+size:], string(delim))
+	if len(parts) != 3 || (parts[2] != "" && parts[2] != "i") {
+		return TagValueRule{}, fmt.Errorf("invalid replace rule: %s", action)
+	}
+	pattern := parts[0]
+	if parts[2] == "i" {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return TagValueRule{}, fmt.Errorf("invalid replace rule: %w", err)
+	}
+	rule.Action = TagValueReplace
+	rule.Pattern = re
+	rule.Replacement = parts[1]
+	return rule, nil
+}
+
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		wordStart := unicode.IsSpace(prev) || strings.ContainsRune("([-", prev)
+		prev = r
+		if wordStart {
+			return unicode.ToTitle(r)
+		}
+		return unicode.ToLower(r)
+	}, s)
+}
+
+// Values that become empty are dropped
+func (r *TagValueRule) apply(values []string) []string {
+	res := []string{}
+	for i, v := range values {
+		switch r.Action {
+		case TagValueReplace:
+			v = strings.TrimSpace(r.Pattern.ReplaceAllString(v, r.Replacement))
+		case TagValueUpper:
+			v = strings.ToUpper(v)
+		case TagValueLower:
+			v = strings.ToLower(v)
+		case TagValueTitle:
+			v = titleCase(v)
+		case TagValueFirst:
+			if i > 0 {
+				continue
+			}
+		case TagValueUnique:
+			if slices.Contains(res, v) {
+				continue
+			}
+		}
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (c *TagCleanup) applyRules(key string, values []string) []string {
+	for i := range c.Rules {
+		if c.Rules[i].Keys.Contains(key) {
+			values = c.Rules[i].apply(values)
+		}
+	}
+	return values
+}
+
+func (c *TagCleanup) removes(key string) bool {
+	return c.Remove.Contains(key) || (len(c.KeepOnly) != 0 && !c.KeepOnly.Contains(key))
+}
+
+// Updates tags to write in place. Tags mapped to empty values are to be removed from the file
+func (c *TagCleanup) apply(existingTags, actualTags map[string][]string) {
+	for k, v := range actualTags {
+		actualTags[k] = c.applyRules(k, v)
+	}
+	for k, v := range existingTags {
+		if _, ok := actualTags[k]; ok {
+			continue
+		}
+		if cleaned := c.applyRules(k, v); !slices.Equal(cleaned, v) {
+			actualTags[k] = cleaned
+		}
+	}
+	for k, v := range actualTags {
+		if _, ok := existingTags[k]; !ok && (len(v) == 0 || c.removes(k)) {
+			delete(actualTags, k)
+		}
+	}
+	for k := range existingTags {
+		if c.removes(k) {
+			actualTags[k] = []string{}
+		}
+	}
+}
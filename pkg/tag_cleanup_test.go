@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"reflect"
+	"testing"
+
+	"go.senan.xyz/taglib"
+)
+
+func TestParseTagValueRule(t *testing.T) {
+	t.Run("Happy path", func(t *testing.T) {
+		tests := []struct {
+			input  string
+			values []string
+			keys   TagKeySet
+			result []string
+		}{
+			{"comment:s/ripped by.*//i", []string{"Ripped by someone", "Nice"}, TagKeySet{"COMMENT": {}}, []string{"Nice"}},
+			{"TITLE,ALBUM:s|(\\d+)/(\\d+)|$1 of $2|", []string{"Disc 1/2"}, TagKeySet{"TITLE": {}, "ALBUM": {}}, []string{"Disc 1 of 2"}},
+			{"*:UPPER", []string{"abc"}, TagKeySet{"*": {}}, []string{"ABC"}},
+			{"GENRE:lower", []string{"ROCK"}, TagKeySet{"GENRE": {}}, []string{"rock"}},
+			{"TITLE:title", []string{"the END (of the WORLD)-part"}, TagKeySet{"TITLE": {}}, []string{"The End (Of The World)-Part"}},
+			{"ARTIST:first", []string{"A", "B"}, TagKeySet{"ARTIST": {}}, []string{"A"}},
+			{"ARTIST:unique", []string{"A", "B", "A"}, TagKeySet{"ARTIST": {}}, []string{"A", "B"}},
+		}
+		for _, tt := range tests {
+			rule, err := ParseTagValueRule(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error for %s: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(rule.Keys, tt.keys) {
+				t.Fatalf("expected keys %v for %s, got %v", tt.keys, tt.input, rule.Keys)
+			}
+			if res := rule.apply(tt.values); !reflect.DeepEqual(res, tt.result) {
+				t.Fatalf("expected %v for %s, got %v", tt.result, tt.input, res)
+			}
+		}
+	})
+
+	t.Run("Invalid rule format", func(t *testing.T) {
+		for _, input := range []string{"COMMENT", ":upper", "COMMENT:swap", "COMMENT:s/a/b", "COMMENT:s/a/b/g", "COMMENT:s/(/b/"} {
+			if _, err := ParseTagValueRule(input); err == nil {
+				t.Fatalf("expected error for %s", input)
+			}
+		}
+	})
+}
+
+func TestTagCleanup(t *testing.T) {
+	mustParse := func(value string) TagValueRule {
+		rule, err := ParseTagValueRule(value)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return rule
+	}
+	existingTags := map[string][]string{
+		taglib.Artist:    {"A", "B"},
+		taglib.Comment:   {"ripped by someone"},
+		taglib.EncodedBy: {"LAME"},
+		taglib.Title:     {"song"},
+	}
+
+	t.Run("happy path removes, cleans existing and new tags", func(t *testing.T) {
+		cleanup := TagCleanup{
+			Remove: TagKeySet{"ENCODEDBY": {}},
+			Rules:  []TagValueRule{mustParse("COMMENT:s/ripped by.*//"), mustParse("ARTIST:first"), mustParse("TITLE,ALBUM:title")},
+		}
+		actualTags := map[string][]string{
+			taglib.Album:     {"my album"},
+			taglib.EncodedBy: {"Other"},
+			taglib.Composer:  {"ripped by someone"},
+		}
+		cleanup.apply(existingTags, actualTags)
+		expected := map[string][]string{
+			taglib.Album:     {"My Album"},
+			taglib.Artist:    {"A"},
+			taglib.Comment:   {},
+			taglib.Composer:  {"ripped by someone"},
+			taglib.EncodedBy: {},
+			taglib.Title:     {"Song"},
+		}
+		if !reflect.DeepEqual(actualTags, expected) {
+			t.Fatalf("expected %v, got %v", expected, actualTags)
+		}
+	})
+
+	t.Run("happy path keeps only some tags", func(t *testing.T) {
+		cleanup := TagCleanup{KeepOnly: TagKeySet{"TITLE": {}, "ALBUM": {}}}
+		actualTags := map[string][]string{
+			taglib.Album: {"My Album"},
+			taglib.Genre: {"Rock"},
+		}
+		cleanup.apply(existingTags, actualTags)
+		expected := map[string][]string{
+			taglib.Album:     {"My Album"},
+			taglib.Artist:    {},
+			taglib.Comment:   {},
+			taglib.EncodedBy: {},
+		}
+		if !reflect.DeepEqual(actualTags, expected) {
+			t.Fatalf("expected %v, got %v", expected, actualTags)
+		}
+	})
+
+	t.Run("happy path does nothing without rules", func(t *testing.T) {
+		actualTags := map[string][]string{taglib.Album: {"My Album"}}
+		(&TagCleanup{}).apply(existingTags, actualTags)
+		expected := map[string][]string{taglib.Album: {"My Album"}}
+		if !reflect.DeepEqual(actualTags, expected) {
+			t.Fatalf("expected %v, got %v", expected, actualTags)
+		}
+	})
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.senan.xyz/taglib"
+)
+
+// A row of a tag table, as exported to and imported from spreadsheets
+type TagRow struct {
+	// Slash separated path relative to the exported folder
+	File string
+	Tags map[string][]string
+}
+
+const tagTableFileColumn = "FILE"
+
+func readTagTable(
+	logger *slog.Logger,
+	osReadDir func(name string) ([]os.DirEntry, error),
+	taglibReadTags func(path string) (map[string][]string, error),
+	workpath string,
+	recursive bool,
+) ([]TagRow, error) {
+	rows := []TagRow{}
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		dirEntries, err := osReadDir(filepath.Join(workpath, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		for _, dirEntry := range dirEntries {
+			name := path.Join(rel, dirEntry.Name())
+			if dirEntry.IsDir() {
+				if recursive {
+					if err := walk(name); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			upperExt := strings.ToUpper(strings.TrimPrefix(filepath.Ext(dirEntry.Name()), "."))
+			if !musicExts.Contains(upperExt) {
+				continue
+			}
+			tags, err := taglibReadTags(filepath.Join(workpath, filepath.FromSlash(name)))
+			if err != nil {
+				logger.Error(fmt.Sprintf("failed to read tags for %s: %s", name, err.Error()))
+				continue
+			}
+			rows = append(rows, TagRow{File: name, Tags: tags})
+		}
+		return nil
+	}
+	if err := walk(""); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Reads tags of all music files in a folder, and in its subfolders if recursive
+func ReadTagTable(logger *slog.Logger, workpath string, recursive bool) ([]TagRow, error) {
+	return readTagTable(logger, os.ReadDir, taglib.ReadTags, workpath, recursive)
+}
+
+func tagTableKeys(rows []TagRow) []string {
+	keys := []string{}
+	for _, row := range rows {
+		for k := range row.Tags {
+			if !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// One row per file, one column per tag key. Multiple values are joined with "; "
+func WriteTagTableCSV(w io.Writer, rows []TagRow) error {
+	keys := tagTableKeys(rows)
+	writer := csv.NewWriter(w)
+	if err := writer.Write(append([]string{tagTableFileColumn}, keys...)); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, 0, len(keys)+1)
+		record = append(record, row.File)
+		for _, k := range keys {
+			record = append(record, strings.Join(row.Tags[k], multiValueJoiner))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func WriteTagTableJSON(w io.Writer, rows []TagRow) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+// Empty cells are treated as absent tags. Cells of multi-valued tags are split by "; "
+func ReadTagTableCSV(r io.Reader) ([]TagRow, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if len(header) == 0 || strings.ToUpper(strings.TrimSpace(header[0])) != tagTableFileColumn {
+		return nil, fmt.Errorf("first column must be %s", tagTableFileColumn)
+	}
+	rows := []TagRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		row := TagRow{File: record[0], Tags: map[string][]string{}}
+		for i, v := range record[1:] {
+			if v == "" {
+				continue
+			}
+			key := strings.ToUpper(strings.TrimSpace(header[i+1]))
+			if MultiValueTags.Contains(key) {
+				row.Tags[key] = strings.Split(v, multiValueJoiner)
+			} else {
+				row.Tags[key] = []string{v}
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func ReadTagTableJSON(r io.Reader) ([]TagRow, error) {
+	var rows []TagRow
+	if err := json.NewDecoder(r).Decode(&rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Groups rows by their folder relative to the exported one, then maps file names to tags. Rows
+// whose path leads outside the folder are rejected
+func TagTableToFileTags(rows []TagRow) (map[string]map[string]map[string][]string, error) {
+	res := map[string]map[string]map[string][]string{}
+	for _, row := range rows {
+		file := path.Clean(row.File)
+		if !filepath.IsLocal(filepath.FromSlash(file)) {
+			return nil, fmt.Errorf("file path outside of the folder: %s", row.File)
+		}
+		dir, name := path.Split(file)
+		dir = filepath.FromSlash(path.Clean(dir))
+		if _, ok := res[dir]; !ok {
+			res[dir] = map[string]map[string][]string{}
+		}
+		tags := map[string][]string{}
+		for k, v := range row.Tags {
+			if len(v) != 0 {
+				tags[strings.ToUpper(k)] = v
+			}
+		}
+		res[dir][name] = tags
+	}
+	return res, nil
+}
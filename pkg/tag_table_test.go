@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.senan.xyz/taglib"
+)
+
+func TestReadTagTable(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	mkOsReadDir := func(name string) ([]os.DirEntry, error) {
+		switch name {
+		case "Library":
+			return []os.DirEntry{
+				&mockDirEntry{name: "My Album", isDir: true},
+				&mockDirEntry{name: "loose.mp3"},
+				&mockDirEntry{name: "info.json"},
+			}, nil
+		case filepath.Join("Library", "My Album"):
+			return []os.DirEntry{
+				&mockDirEntry{name: "01. Song1.flac"},
+				&mockDirEntry{name: "02. Broken.flac"},
+			}, nil
+		}
+		t.Fatalf("unexpected read of %s", name)
+		return nil, nil
+	}
+	mkReadTags := func(path string) (map[string][]string, error) {
+		switch path {
+		case filepath.Join("Library", "loose.mp3"):
+			return map[string][]string{taglib.Title: {"Loose"}}, nil
+		case filepath.Join("Library", "My Album", "01. Song1.flac"):
+			return map[string][]string{taglib.Title: {"Song1"}, taglib.Artist: {"A", "B"}}, nil
+		}
+		return nil, fmt.Errorf("invalid file")
+	}
+
+	t.Run("happy path reads only the top folder", func(t *testing.T) {
+		rows, err := readTagTable(logger, mkOsReadDir, mkReadTags, "Library", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []TagRow{{File: "loose.mp3", Tags: map[string][]string{taglib.Title: {"Loose"}}}}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("expected %v, got %v", expected, rows)
+		}
+	})
+
+	t.Run("happy path reads subfolders and skips unreadable files", func(t *testing.T) {
+		rows, err := readTagTable(logger, mkOsReadDir, mkReadTags, "Library", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []TagRow{
+			{File: "My Album/01. Song1.flac", Tags: map[string][]string{taglib.Title: {"Song1"}, taglib.Artist: {"A", "B"}}},
+			{File: "loose.mp3", Tags: map[string][]string{taglib.Title: {"Loose"}}},
+		}
+		if !reflect.DeepEqual(rows, expected) {
+			t.Fatalf("expected %v, got %v", expected, rows)
+		}
+	})
+}
+
+func TestTagTableCSV(t *testing.T) {
+	rows := []TagRow{
+		{File: "My Album/01. Song1.flac", Tags: map[string][]string{taglib.Title: {"Song1"}, taglib.Artist: {"A", "B"}}},
+		{File: "loose.mp3", Tags: map[string][]string{taglib.Title: {"Loose, \"quoted\""}}},
+	}
+
+	t.Run("happy path writes one row per file", func(t *testing.T) {
+		buffer := new(bytes.Buffer)
+		if err := WriteTagTableCSV(buffer, rows); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := "FILE,ARTIST,TITLE\n" +
+			"My Album/01. Song1.flac,A; B,Song1\n" +
+			"loose.mp3,,\"Loose, \"\"quoted\"\"\"\n"
+		if buffer.String() != expected {
+			t.Fatalf("expected %q, got %q", expected, buffer.String())
+		}
+	})
+
+	t.Run("happy path reads back, splits multi-valued tags and drops empty cells", func(t *testing.T) {
+		input := "file,artist,Title\n" +
+			"My Album/01. Song1.flac,A; B,Song1\n" +
+			"loose.mp3,,\"Loose, \"\"quoted\"\"\"\n"
+		res, err := ReadTagTableCSV(strings.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []TagRow{
+			{File: "My Album/01. Song1.flac", Tags: map[string][]string{taglib.Title: {"Song1"}, taglib.Artist: {"A", "B"}}},
+			{File: "loose.mp3", Tags: map[string][]string{taglib.Title: {"Loose, \"quoted\""}}},
+		}
+		if !reflect.DeepEqual(res, expected) {
+			t.Fatalf("expected %v, got %v", expected, res)
+		}
+	})
+
+	t.Run("missing file column", func(t *testing.T) {
+		if _, err := ReadTagTableCSV(strings.NewReader("TITLE\nSong1\n")); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+func TestTagTableJSON(t *testing.T) {
+	rows := []TagRow{
+		{File: "01. Song1.flac", Tags: map[string][]string{taglib.Title: {"Song1"}, taglib.Artist: {"A", "B"}}},
+	}
+	buffer := new(bytes.Buffer)
+	if err := WriteTagTableJSON(buffer, rows); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res, err := ReadTagTableJSON(buffer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(res, rows) {
+		t.Fatalf("expected %v, got %v", rows, res)
+	}
+}
+
+func TestTagTableToFileTags(t *testing.T) {
+	rows := []TagRow{
+		{File: "My Album/01. Song1.flac", Tags: map[string][]string{"title": {"Song1"}, taglib.Artist: {"A", "B"}, taglib.Genre: {}}},
+		{File: "loose.mp3", Tags: map[string][]string{taglib.Title: {"Loose"}}},
+	}
+	expected := map[string]map[string]map[string][]string{
+		"My Album": {
+			"01. Song1.flac": {taglib.Title: {"Song1"}, taglib.Artist: {"A", "B"}},
+		},
+		".": {
+			"loose.mp3": {taglib.Title: {"Loose"}},
+		},
+	}
+	if res, err := TagTableToFileTags(rows); err != nil || !reflect.DeepEqual(res, expected) {
+		t.Fatalf("expected %v, got %v %v", expected, res, err)
+	}
+
+	t.Run("paths outside of the folder", func(t *testing.T) {
+		for _, file := range []string{"../other/01. Song1.flac", "My Album/../../01. Song1.flac", "/etc/01. Song1.flac", ".."} {
+			if _, err := TagTableToFileTags([]TagRow{{File: file}}); err == nil {
+				t.Fatalf("expected error for %s, got nil", file)
+			}
+		}
+	})
+}
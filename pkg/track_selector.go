@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type TrackNumberKey struct{ DiscNumber, TrackNumber string }
+
+// Chooses tracks by disc and track numbers or by name. Zero bounds of a range are open, so the
+// zero selector matches all tracks
+type TrackSelector struct {
+	// Excludes the matching tracks from the set
+	Exclude            bool
+	DiscFrom, DiscTo   int
+	TrackFrom, TrackTo int
+	// Lower case text contained in the track name
+	Name      string
+	NameRegex *regexp.Regexp
+	// Page URL of exactly one track, for tracks chosen one by one
+	PageUrl string
+}
+
+func inNumberRange(v string, from, to int) bool {
+	if from == 0 && to == 0 {
+		return true
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	return err == nil && (from == 0 || n >= from) && (to == 0 || n <= to)
+}
+
+func (sel *TrackSelector) Matches(info *TrackInfo) bool {
+	return inNumberRange(info.DiscNumber, sel.DiscFrom, sel.DiscTo) &&
+		inNumberRange(info.TrackNumber, sel.TrackFrom, sel.TrackTo) &&
+		(sel.Name == "" || strings.Contains(strings.ToLower(info.Name), sel.Name)) &&
+		(sel.NameRegex == nil || sel.NameRegex.MatchString(info.Name)) &&
+		(sel.PageUrl == "" || sel.PageUrl == info.PageUrl)
+}
+
+func formatNumberRange(from, to int) string {
+	switch {
+	case from == 0 && to == 0:
+		return "*"
+	case from == to:
+		return strconv.Itoa(from)
+	}
+	return strconv.Itoa(from) + "-" + strconv.Itoa(to)
+}
+
+// Formats the selector as parsed by ParseTrackSelectors
+func (sel TrackSelector) String() string {
+	prefix := ""
+	if sel.Exclude {
+		prefix = "!"
+	}
+	switch {
+	case sel.Name != "":
+		return prefix + "~" + sel.Name
+	case sel.NameRegex != nil:
+		return prefix + "/" + sel.NameRegex.String() + "/"
+	case sel.PageUrl != "":
+		return prefix + "@" + sel.PageUrl
+	case sel.DiscFrom == 0 && sel.DiscTo == 0 && sel.TrackFrom == sel.TrackTo:
+		return prefix + formatNumberRange(sel.TrackFrom, sel.TrackTo)
+	}
+	return prefix + formatNumberRange(sel.DiscFrom, sel.DiscTo) + ":" + formatNumberRange(sel.TrackFrom, sel.TrackTo)
+}
+
+// Tracks matching any selector, without those matching an excluding one. With only excluding
+// selectors, all other tracks are contained
+type TrackNumberSet []TrackSelector
+
+var DownloadAllTracks = TrackNumberSet{{}}
+
+func (s TrackNumberSet) String() string {
+	parts := make([]string, len(s))
+	for i, sel := range s {
+		parts[i] = sel.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s TrackNumberSet) Contains(info *TrackInfo) bool {
+	included, hasInclusions := false, false
+	for i := range s {
+		sel := &s[i]
+		if !sel.Exclude {
+			hasInclusions = true
+		}
+		if sel.Matches(info) {
+			if sel.Exclude {
+				return false
+			}
+			included = true
+		}
+	}
+	return included || (len(s) > 0 && !hasInclusions)
+}
+
+// Adds a disc and track number, each of which may be '*' for any. An empty disc number also
+// means any. Numbers that are not numeric match no track
+func (s *TrackNumberSet) Add(key TrackNumberKey) {
+	bound := func(v string) int {
+		if v == "" || v == "*" {
+			return 0
+		}
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+		return -1
+	}
+	disc, track := bound(key.DiscNumber), bound(key.TrackNumber)
+	*s = append(*s, TrackSelector{DiscFrom: disc, DiscTo: disc, TrackFrom: track, TrackTo: track})
+}
+
+// Adds exactly the track, whatever its disc and track numbers are. The track must have a page URL
+func (s *TrackNumberSet) AddTrack(info *TrackInfo) {
+	*s = append(*s, TrackSelector{PageUrl: info.PageUrl})
+}
+
+// Parses '*', 'n' or 'n-m' where numbers start at 1
+func parseNumberRange(v string) (int, int, error) {
+	if v == "*" {
+		return 0, 0, nil
+	}
+	fromText, toText, isRange := strings.Cut(v, "-")
+	from, err := strconv.Atoi(fromText)
+	to := from
+	if err == nil && isRange {
+		to, err = strconv.Atoi(toText)
+	}
+	if err != nil || from < 1 || to < from {
+		return 0, 0, fmt.Errorf("invalid number or range: %q", v)
+	}
+	return from, to, nil
+}
+
+// Parses comma separated track selectors:
+//
+//	'*'        all tracks
+//	5, *:3-12  tracks by number on any disc
+//	1:3-12     tracks of a disc, where discs may be a range or '*'
+//	~word      tracks whose name contains the word, ignoring case
+//	/regexp/   tracks whose name matches the regular expression
+//	@url       the track of the page URL
+//	!selector  excludes the tracks of the selector
+func ParseTrackSelectors(value string) (TrackNumberSet, error) {
+	var set TrackNumberSet
+	for part := range strings.SplitSeq(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		sel := TrackSelector{}
+		text := part
+		if rest, ok := strings.CutPrefix(text, "!"); ok {
+			sel.Exclude, text = true, strings.TrimSpace(rest)
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(text, "~"):
+			sel.Name = strings.ToLower(strings.TrimSpace(text[1:]))
+			if sel.Name == "" {
+				err = fmt.Errorf("empty name")
+			}
+		case len(text) >= 2 && strings.HasPrefix(text, "/") && strings.HasSuffix(text, "/"):
+			sel.NameRegex, err = regexp.Compile(text[1 : len(text)-1])
+		case strings.HasPrefix(text, "@"):
+			sel.PageUrl = strings.TrimSpace(text[1:])
+			if sel.PageUrl == "" {
+				err = fmt.Errorf("empty page url")
+			}
+		default:
+			disc, track, hasDisc := strings.Cut(text, ":")
+			if !hasDisc {
+				disc, track = "*", disc
+			}
+			// Earlier versions read d-t as disc d track t, which may be in saved flags and jobs
+			if from, to, isRange := strings.Cut(text, "-"); !hasDisc && isRange {
+				err = fmt.Errorf("ambiguous range, write %s:%s for disc %s track %s, or *:%s for tracks on any disc", from, to, from, to, text)
+				break
+			}
+			if sel.DiscFrom, sel.DiscTo, err = parseNumberRange(strings.TrimSpace(disc)); err == nil {
+				sel.TrackFrom, sel.TrackTo, err = parseNumberRange(strings.TrimSpace(track))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid track selector %q: %w", part, err)
+		}
+		set = append(set, sel)
+	}
+	return set, nil
+}
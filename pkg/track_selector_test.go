@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTrackSelectors(t *testing.T) {
+	tracks := []TrackInfo{
+		{Name: "Opening", DiscNumber: "1", TrackNumber: "01"},
+		{Name: "Battle Theme", DiscNumber: "1", TrackNumber: "02"},
+		{Name: "Battle Theme (Remix)", DiscNumber: "1", TrackNumber: "03"},
+		{Name: "Ending", DiscNumber: "2", TrackNumber: "01"},
+		{Name: "Bonus", TrackNumber: "A1", PageUrl: "https://example.com/bonus.mp3"},
+	}
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"*", "Opening,Battle Theme,Battle Theme (Remix),Ending,Bonus"},
+		{"1", "Opening,Ending"},
+		{"1:2-3", "Battle Theme,Battle Theme (Remix)"},
+		{"*:1,1-2:3", "Opening,Battle Theme (Remix),Ending"},
+		{"!2:*", "Opening,Battle Theme,Battle Theme (Remix),Bonus"},
+		{"1:*,!~remix", "Opening,Battle Theme"},
+		{"/^(?i)b/", "Battle Theme,Battle Theme (Remix),Bonus"},
+		{"~theme, !/Remix\\)$/", "Battle Theme"},
+		{"@https://example.com/bonus.mp3", "Bonus"},
+	}
+	for _, tt := range tests {
+		set, err := ParseTrackSelectors(tt.value)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", tt.value, err)
+		}
+		var names []string
+		for i := range tracks {
+			if set.Contains(&tracks[i]) {
+				names = append(names, tracks[i].Name)
+			}
+		}
+		if res := strings.Join(names, ","); res != tt.expected {
+			t.Fatalf("expected %v for %s, got %v", tt.expected, tt.value, res)
+		}
+	}
+
+	t.Run("invalid selectors", func(t *testing.T) {
+		for _, value := range []string{"x", "1:a", "a:1", "0", "3-1", "1-", "!", "~ ", "/[/", "@"} {
+			if _, err := ParseTrackSelectors(value); err == nil {
+				t.Fatalf("expected error for %s, got nil", value)
+			}
+		}
+	})
+}
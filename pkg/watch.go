@@ -0,0 +1,183 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	URL "net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Reads album URLs, one per line. Blank lines and lines starting with # are ignored
+func ReadWatchList(r io.Reader) ([]string, error) {
+	var urls []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := URL.ParseRequestURI(line); err != nil {
+			return nil, fmt.Errorf("invalid url in watch list: %s", line)
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// Folder an album is downloaded into under workPath
+func AlbumFolder(workPath string, albumInfo *AlbumInfo) string {
+	return path.Join(workPath, sanitizeFilename(albumInfo.Name))
+}
+
+func readAlbumInfoFile(osOpen func(string) (io.ReadCloser, error), folder string) (*AlbumInfo, error) {
+	f, err := osOpen(path.Join(folder, "info.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var albumInfo AlbumInfo
+	if err := json.NewDecoder(f).Decode(&albumInfo); err != nil {
+		return nil, fmt.Errorf("failed to read info.json of %s: %w", folder, err)
+	}
+	return &albumInfo, nil
+}
+
+// Reads the info.json of an album folder. The error matches os.ErrNotExist if there is none
+func ReadAlbumInfoFile(folder string) (*AlbumInfo, error) {
+	osOpen := func(name string) (io.ReadCloser, error) {
+		return os.Open(name) // covariance
+	}
+	return readAlbumInfoFile(osOpen, folder)
+}
+
+// Differences of an album page from the info.json of an earlier download
+type AlbumChanges struct {
+	NewTracks []TrackInfo
+	NewImages []ImageInfo
+	// Tracks no longer on the page. They are only reported
+	RemovedTracks []TrackInfo
+}
+
+func (c AlbumChanges) Empty() bool {
+	return len(c.NewTracks) == 0 && len(c.NewImages) == 0 && len(c.RemovedTracks) == 0
+}
+
+func trackKeys(t *TrackInfo) []string {
+	keys := []string{t.DiscNumber + "\x00" + t.TrackNumber + "\x00" + t.Name}
+	if t.PageUrl != "" {
+		keys = append(keys, t.PageUrl)
+	}
+	return keys
+}
+
+// Tracks are matched by page URL or by number and name. Images are matched by file name, as
+// the host serving them may change
+func CompareAlbums(local, remote *AlbumInfo) AlbumChanges {
+	var changes AlbumChanges
+	diffTracks := func(from, to []TrackInfo) []TrackInfo {
+		known := map[string]bool{}
+		for i := range to {
+			for _, key := range trackKeys(&to[i]) {
+				known[key] = true
+			}
+		}
+		var res []TrackInfo
+		for i := range from {
+			found := false
+			for _, key := range trackKeys(&from[i]) {
+				found = found || known[key]
+			}
+			if !found {
+				res = append(res, from[i])
+			}
+		}
+		return res
+	}
+	changes.NewTracks = diffTracks(remote.Tracks, local.Tracks)
+	changes.RemovedTracks = diffTracks(local.Tracks, remote.Tracks)
+
+	images := map[string]bool{}
+	for _, imgInfo := range local.Images {
+		images[downloadPath("", imgInfo.ImageUrl)] = true
+	}
+	for _, imgInfo := range remote.Images {
+		if !images[downloadPath("", imgInfo.ImageUrl)] {
+			changes.NewImages = append(changes.NewImages, imgInfo)
+		}
+	}
+	return changes
+}
+
+// Copies the download URLs and sizes recorded locally to matching tracks of the album page, so
+// that info.json written for the new tracks keeps them
+func MergeTrackDownloads(local, remote *AlbumInfo) {
+	byKey := map[string]*TrackInfo{}
+	for i := range local.Tracks {
+		for _, key := range trackKeys(&local.Tracks[i]) {
+			byKey[key] = &local.Tracks[i]
+		}
+	}
+	for i := range remote.Tracks {
+		t := &remote.Tracks[i]
+		for _, key := range trackKeys(t) {
+			if l, ok := byKey[key]; ok {
+				if len(t.SongUrl) == 0 {
+					t.SongUrl = l.SongUrl
+				}
+				if len(t.Sizes) == 0 {
+					t.Sizes = l.Sizes
+				}
+				break
+			}
+		}
+	}
+}
+
+type AlbumCheck struct {
+	// Info of the earlier download, empty if there is none
+	Local   *AlbumInfo
+	Remote  *AlbumInfo
+	Folder  string
+	Changes AlbumChanges
+}
+
+func checkAlbum(
+	ctx context.Context,
+	httpClient HttpDoClient,
+	osOpen func(string) (io.ReadCloser, error),
+	history []HistoryEntry,
+	workPath,
+	albumUrl string,
+) (*AlbumCheck, error) {
+	remote, err := FetchAlbumInfo(ctx, httpClient, albumUrl)
+	if err != nil {
+		return nil, err
+	}
+	check := &AlbumCheck{Remote: remote, Folder: AlbumFolder(workPath, remote)}
+	if last := LastDownload(history, albumUrl); last != nil {
+		check.Folder = last.Folder
+	}
+	check.Local, err = readAlbumInfoFile(osOpen, check.Folder)
+	if errors.Is(err, os.ErrNotExist) {
+		check.Local = &AlbumInfo{}
+	} else if err != nil {
+		return nil, err
+	}
+	check.Changes = CompareAlbums(check.Local, remote)
+	return check, nil
+}
+
+// Fetches an album page and compares it with the info.json of its folder, which is the folder of
+// the last download in history if any, otherwise the album folder under workPath
+func CheckAlbum(ctx context.Context, httpClient HttpDoClient, history []HistoryEntry, workPath, albumUrl string) (*AlbumCheck, error) {
+	osOpen := func(name string) (io.ReadCloser, error) {
+		return os.Open(name) // covariance
+	}
+	return checkAlbum(ctx, httpClient, osOpen, history, workPath, albumUrl)
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadWatchList(t *testing.T) {
+	t.Run("happy path skips comments", func(t *testing.T) {
+		urls, err := ReadWatchList(strings.NewReader("# ongoing\nhttps://example.com/album1\n\n  https://example.com/album2  \n"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []string{"https://example.com/album1", "https://example.com/album2"}
+		if !reflect.DeepEqual(urls, expected) {
+			t.Fatalf("expected %v, got %v", expected, urls)
+		}
+	})
+
+	t.Run("invalid url", func(t *testing.T) {
+		if _, err := ReadWatchList(strings.NewReader("album1\n")); err == nil {
+			t.Fatalf("expected error")
+		}
+	})
+}
+
+func TestReadAlbumInfoFile(t *testing.T) {
+	osOpen := func(name string) (io.ReadCloser, error) {
+		if name == "My Album/info.json" {
+			return io.NopCloser(strings.NewReader(`{"Name": "My Album"}`)), nil
+		}
+		return nil, os.ErrNotExist
+	}
+	albumInfo, err := readAlbumInfoFile(osOpen, "My Album")
+	if err != nil || albumInfo.Name != "My Album" {
+		t.Fatalf("expected My Album, got %v, %v", albumInfo, err)
+	}
+	if _, err := readAlbumInfoFile(osOpen, "Other"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected %v, got %v", os.ErrNotExist, err)
+	}
+}
+
+func TestCompareAlbums(t *testing.T) {
+	local := &AlbumInfo{
+		Images: []ImageInfo{{ImageUrl: "https://old.com/Cover.jpg"}},
+		Tracks: []TrackInfo{
+			{Name: "song1", TrackNumber: "1", PageUrl: "https://example.com/01.%2520song1.mp3", SongUrl: map[string]string{"FLAC": "https://download.com/01.flac"}},
+			{Name: "song2", TrackNumber: "2"},
+			{Name: "song3", TrackNumber: "3", PageUrl: "https://example.com/03.%2520song3.mp3"},
+		},
+	}
+	remote := &AlbumInfo{
+		Images: []ImageInfo{{ImageUrl: "https://download.com/Cover.jpg"}, {ImageUrl: "https://download.com/Back.jpg"}},
+		Tracks: []TrackInfo{
+			{Name: "song1", TrackNumber: "1", PageUrl: "https://example.com/01.%2520song1.mp3"},
+			{Name: "song2", TrackNumber: "2", PageUrl: "https://example.com/02.%2520song2.mp3"},
+			{Name: "song4", TrackNumber: "4", PageUrl: "https://example.com/04.%2520song4.mp3"},
+		},
+	}
+
+	changes := CompareAlbums(local, remote)
+	expected := AlbumChanges{
+		NewTracks:     []TrackInfo{remote.Tracks[2]},
+		NewImages:     []ImageInfo{remote.Images[1]},
+		RemovedTracks: []TrackInfo{local.Tracks[2]},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, got %v", expected, changes)
+	}
+	if !CompareAlbums(remote, remote).Empty() {
+		t.Fatalf("expected no changes")
+	}
+
+	MergeTrackDownloads(local, remote)
+	if remote.Tracks[0].SongUrl["FLAC"] != "https://download.com/01.flac" || remote.Tracks[2].SongUrl != nil {
+		t.Fatalf("expected only song1 to have recorded urls, got %v", remote.Tracks)
+	}
+}
+
+func TestCheckAlbum(t *testing.T) {
+	client := stubClient{
+		"https://example.com/": {"GET": {http.StatusOK, home1}},
+	}
+	history := []HistoryEntry{{Url: "https://example.com", Folder: "moved/My Album 1"}}
+	osOpen := func(name string) (io.ReadCloser, error) {
+		if name == "moved/My Album 1/info.json" {
+			return io.NopCloser(strings.NewReader(`{"Name": "My Album 1", "Tracks": [{"Name": "song1", "TrackNumber": "1", "PageUrl": "https://example.com/01.%2520song1.mp3"}]}`)), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	t.Run("happy path finds new items in the moved folder", func(t *testing.T) {
+		check, err := checkAlbum(context.Background(), client, osOpen, history, "music", "https://example.com/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if check.Folder != "moved/My Album 1" || len(check.Changes.NewTracks) != 1 || check.Changes.NewTracks[0].Name != "song2" || len(check.Changes.NewImages) != 1 {
+			t.Fatalf("expected song2 and the cover to be new, got %v", check.Changes)
+		}
+	})
+
+	t.Run("happy path everything is new without download", func(t *testing.T) {
+		check, err := checkAlbum(context.Background(), client, osOpen, nil, "music", "https://example.com/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if check.Folder != "music/My Album 1" || len(check.Changes.NewTracks) != 2 {
+			t.Fatalf("expected all tracks to be new, got %v", check.Changes)
+		}
+	})
+}